@@ -1,6 +1,7 @@
 package consumer
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,6 +12,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// upcomingOccurrencesLimit caps how many future occurrences are returned for a recurring series
+const upcomingOccurrencesLimit = 10
+
 // GetAllAppointments godoc
 func GetAllAppointments(c *fiber.Ctx) error {
 	var appointments []models.Appointment
@@ -27,7 +31,7 @@ func GetAllAppointments(c *fiber.Ctx) error {
 func GetAppointment(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var appointment models.Appointment
-	if err := db.DB.Preload("Service").Preload("Provider").Preload("Customer").First(&appointment, id).Error; err != nil {
+	if err := db.DB.Preload("Service").Preload("Provider").Preload("Customer").Preload("Options").First(&appointment, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
 			Message: "Appointment not found",
 			Error:   err.Error(),
@@ -48,7 +52,7 @@ func GetServiceDetails(c *fiber.Ctx) error {
 	}
 
 	// Optionally preload provider or category
-	if err := db.DB.Preload("Provider").Preload("Category").First(&service, id).Error; err != nil {
+	if err := db.DB.Preload("Provider").Preload("Category").Preload("Options").First(&service, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Service not found",
 		})
@@ -68,6 +72,7 @@ func CreateAppointment(c *fiber.Ctx) error {
 			Error:   err.Error(),
 		})
 	}
+	clearServerFields(&appointment)
 	fmt.Println("Parsed appointment:", appointment)
 	// Get the service to calculate duration
 	var service models.Service
@@ -78,75 +83,111 @@ func CreateAppointment(c *fiber.Ctx) error {
 		})
 	}
 	fmt.Println("Fetched service:", service)
-	// Get duration directly from service
-	duration := service.Duration
-	totalDuration := duration + service.BufferTime
-
-	// Convert StartTime to IST before checking availability
-	appointment.StartTime = utils.ToIST(appointment.StartTime)
-	fmt.Println("Converted StartTime to IST:", appointment.StartTime)
-	// Check if the appointment falls within the provider's working hours
-	isWorkingHour, err := utils.CheckWorkingDayAndHours(appointment.ProviderID, appointment.StartTime)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Message: "Error checking working hours",
+
+	// The chosen variant and add-ons set the length of the appointment
+	var selection utils.ServiceSelection
+	if err := c.BodyParser(&selection); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
 			Error:   err.Error(),
 		})
 	}
-	// Check if the appointment is during break time
-	fmt.Println("Checking break time...")
-	if !isWorkingHour {
-		return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
-			Message: "Appointment is outside working hours or during break",
+	quote, err := utils.QuoteService(db.DB, &service, selection)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidOption) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid service option",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to load service options",
+			Error:   err.Error(),
 		})
 	}
-	fmt.Println("Checked break time successfully")
+	duration := quote.Duration
+	appointment.Options = quote.Options
 
-	// Check for availability
-	available, err := utils.CheckAvailability(appointment.ProviderID, appointment.StartTime, totalDuration)
+	// Providers that auto-confirm get the booking confirmed right away, others start pending
+	settings, err := utils.LoadProviderSettings(db.DB, appointment.ProviderID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Message: "Error checking availability",
+			Message: "Failed to load provider settings",
 			Error:   err.Error(),
 		})
 	}
-	if !available {
-		return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
-			Message: "Time slot not available",
-		})
-	}
+	appointment.Status = settings.InitialStatus()
+	loc := utils.LoadTimeZone(settings.TimeZone)
 
-	// Set end time and convert to IST
-	appointment.EndTime = utils.ToIST(appointment.StartTime.Add(duration))
+	// Record the price now so later changes to the service do not rewrite it
+	appointment.SetPrice(quote.Cost, service.Discount, settings.TaxRate)
 
-	// Set status to pending by default
-	appointment.Status = models.StatusPending
+	// Store times in UTC and set end time
+	appointment.StartTime = appointment.StartTime.UTC()
+	appointment.EndTime = appointment.StartTime.Add(duration)
+	fmt.Println("Converted StartTime to UTC:", appointment.StartTime)
 
-	fmt.Println("Setting status to pending:", appointment.Status)
+	// Validate the recurrence rule before booking anything. The series is evaluated in
+	// the provider's time zone so occurrences keep their local time across DST changes.
+	recurrence := appointment.RecurPattern
+	if appointment.IsRecurring {
+		recurrence.TimeZone = loc.String()
+		if err := recurrence.Normalize(appointment.StartTime.In(loc)); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid recurrence rule",
+				Error:   err.Error(),
+			})
+		}
+	}
 
-	// Create appointment and recurrence in a transaction
+	// Reserve the slot and create appointment and recurrence in a single transaction. Without
+	// a staff_id the booking goes to any staff member who is free at that time.
 	err = db.DB.Transaction(func(tx *gorm.DB) error {
-		// Check availability again to prevent conflicts
-		available, err := utils.CheckAvailability(appointment.ProviderID, appointment.StartTime, duration)
+		staffID, err := utils.ReserveStaffSlot(tx, utils.SlotRequest{
+			ProviderID: appointment.ProviderID,
+			ServiceID:  service.ID,
+			StaffID:    appointment.StaffID,
+			CustomerID: appointment.CustomerID,
+			Capacity:   service.Seats(),
+			StartTime:  appointment.StartTime,
+			Duration:   duration,
+			BufferTime: service.BufferTime,
+		})
 		if err != nil {
 			return err
 		}
-		if !available {
-			return fmt.Errorf("time slot not available")
+		appointment.StaffID = staffID
+		appointment.Warnings, err = utils.CheckCustomerOverlaps(tx, utils.CustomerBooking{
+			CustomerID: appointment.CustomerID,
+			ProviderID: appointment.ProviderID,
+			StartTime:  appointment.StartTime,
+			EndTime:    appointment.StartTime.Add(duration),
+		})
+		if err != nil {
+			return err
 		}
 
-		// Create the appointment
-		if err := tx.Create(&appointment).Error; err != nil {
+		// Create the appointment; the recurrence is created explicitly below
+		if err := tx.Omit("RecurPattern").Create(&appointment).Error; err != nil {
+			return err
+		}
+		if err := models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventCreated,
+		}, requestActor(c)); err != nil {
 			return err
 		}
 
 		// Handle Recurrence if `is_recurring` is true
 		if appointment.IsRecurring {
-			recurrence := models.Recurrence{
+			recurrence = models.Recurrence{
 				AppointmentID: appointment.ID,
 				NextRun:       appointment.StartTime,
-				Frequency:     appointment.RecurPattern.Frequency,
-				EndAfter:      appointment.RecurPattern.EndAfter,
+				Frequency:     recurrence.Frequency,
+				EndAfter:      recurrence.EndAfter,
+				RRule:         recurrence.RRule,
+				DTStart:       recurrence.DTStart,
+				TimeZone:      recurrence.TimeZone,
 			}
 
 			// Create the recurrence
@@ -158,17 +199,48 @@ func CreateAppointment(c *fiber.Ctx) error {
 			if err := tx.Model(&appointment).Update("recurrence_id", recurrence.ID).Error; err != nil {
 				return fmt.Errorf("failed to update appointment with recurrence_id: %v", err)
 			}
+			appointment.RecurrenceID = recurrence.ID
 		}
 
 		return nil
 	})
-	fmt.Println("Transaction completed successfully")
 	if err != nil {
-		return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
-			Message: "Time slot not available or failed to create appointment",
+		if utils.IsBookingConflict(err) {
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: utils.BookingErrorMessage(err),
+				Error:   err.Error(),
+			})
+		}
+		if utils.IsStaffError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid staff member",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to create appointment",
 			Error:   err.Error(),
 		})
 	}
+	fmt.Println("Transaction completed successfully")
+
+	// The booking takes over the customer's checkout hold
+	utils.ConsumeSlotHold(appointment.ProviderID, appointment.CustomerID)
+
+	// Book the series up to the rolling horizon right away
+	if appointment.IsRecurring {
+		if _, err := utils.MaterializeRecurrence(&recurrence, utils.RecurrenceHorizon(time.Now())); err != nil {
+			fmt.Println("Failed to materialize recurring appointments:", err)
+		}
+	}
+
+	// Return the computed upcoming occurrences of the series
+	if appointment.IsRecurring {
+		if rule, err := recurrence.Rule(); err == nil {
+			recurrence.Upcoming = rule.Occurrences(appointment.StartTime, upcomingOccurrencesLimit)
+		}
+		appointment.RecurPattern = recurrence
+	}
 	// Find the customer and provider to send emails
 	var customer models.User
 	if err := db.DB.First(&customer, appointment.CustomerID).Error; err != nil {
@@ -204,7 +276,7 @@ func CreateAppointment(c *fiber.Ctx) error {
 		<p>Best regards,</p>
 		<p>Your Appointment Team</p>
 	`, customer.Name, service.Name, provider.Name,
-		appointment.StartTime.Format("2006-01-02 15:04:05"), appointment.EndTime.Format("2006-01-02 15:04:05"),
+		utils.FormatInZone(appointment.StartTime, loc), utils.FormatInZone(appointment.EndTime, loc),
 		appointment.Status)
 	if err := utils.SendEmail(customer.Email, "Appointment Confirmation", emailBody); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
@@ -229,7 +301,7 @@ func CreateAppointment(c *fiber.Ctx) error {
 		<p>Best regards,</p>
 		<p>Your Appointment Team</p>
 	`, provider.Name, service.Name, customer.Name,
-		appointment.StartTime.Format("2006-01-02 15:04:05"), appointment.EndTime.Format("2006-01-02 15:04:05"),
+		utils.FormatInZone(appointment.StartTime, loc), utils.FormatInZone(appointment.EndTime, loc),
 		appointment.Status)
 	if err := utils.SendEmail(provider.Email, "New Appointment Scheduled", emailBody); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
@@ -270,56 +342,112 @@ func UpdateAppointment(c *fiber.Ctx) error {
 			return fmt.Errorf("appointment not found")
 		}
 
-		// Check if start_time or provider_id is being modified
-		isTimeUpdated := updatedAppointment.StartTime != (time.Time{}) && updatedAppointment.StartTime != existingAppointment.StartTime
+		// Preserve existing values if fields are not updated
+		if updatedAppointment.Title == "" {
+			updatedAppointment.Title = existingAppointment.Title
+		}
+		if updatedAppointment.Description == "" {
+			updatedAppointment.Description = existingAppointment.Description
+		}
+		if updatedAppointment.ServiceID == 0 {
+			updatedAppointment.ServiceID = existingAppointment.ServiceID
+		}
+		if updatedAppointment.CustomerID == 0 {
+			updatedAppointment.CustomerID = existingAppointment.CustomerID
+		}
+		// Options and prices are kept by the server and cannot be sent with an update
+		updatedAppointment.Options = nil
+		updatedAppointment.SetPrice(0, 0, 0)
+		clearServerFields(&updatedAppointment)
+		isServiceUpdated := updatedAppointment.ServiceID != existingAppointment.ServiceID
+
+		// Check if start_time, provider_id or staff_id is being modified
+		isTimeUpdated := updatedAppointment.StartTime != (time.Time{}) && !updatedAppointment.StartTime.Equal(existingAppointment.StartTime)
 		isProviderUpdated := updatedAppointment.ProviderID != 0 && updatedAppointment.ProviderID != existingAppointment.ProviderID
+		isStaffUpdated := updatedAppointment.StaffID != nil && !utils.SameStaff(updatedAppointment.StaffID, existingAppointment.StaffID)
+		if updatedAppointment.ProviderID == 0 {
+			updatedAppointment.ProviderID = existingAppointment.ProviderID
+		}
+		if !isTimeUpdated {
+			updatedAppointment.StartTime = existingAppointment.StartTime
+		}
+
+		// Moving the booking to another time is a reschedule under the provider's policy
+		if isTimeUpdated {
+			if _, err := utils.CheckPolicy(tx, &existingAppointment, utils.PolicyReschedule); err != nil {
+				return err
+			}
+			updatedAppointment.RescheduleCount = existingAppointment.RescheduleCount + 1
+		}
+		// Keep the practitioner unless another one was picked; a new provider means new staff
+		if !isStaffUpdated && !isProviderUpdated {
+			updatedAppointment.StaffID = existingAppointment.StaffID
+		}
 
-		// If start_time or provider_id is updated, recheck availability
-		if isTimeUpdated || isProviderUpdated {
+		// If start_time, provider_id, staff_id or service_id is updated, reserve the new slot
+		// through the booking engine; another service changes how long the slot must be
+		updatedAppointment.EndTime = existingAppointment.EndTime
+		if isTimeUpdated || isProviderUpdated || isStaffUpdated || isServiceUpdated {
 			var service models.Service
 			if err := tx.First(&service, updatedAppointment.ServiceID).Error; err != nil {
 				return fmt.Errorf("service not found")
 			}
 
-			duration := service.Duration
+			// Store times in UTC
+			updatedAppointment.StartTime = updatedAppointment.StartTime.UTC()
 
-			// Convert StartTime to IST
-			updatedAppointment.StartTime = utils.ToIST(updatedAppointment.StartTime)
+			// The appointment keeps its length including add-ons, unless it moves to another service
+			duration := existingAppointment.Duration()
+			if isServiceUpdated {
+				duration = service.Duration
+			}
 
-			// Check availability in IST
-			available, err := utils.CheckAvailability(updatedAppointment.ProviderID, updatedAppointment.StartTime, duration)
+			staffID, err := utils.ReserveStaffSlot(tx, utils.SlotRequest{
+				ProviderID:    updatedAppointment.ProviderID,
+				ServiceID:     service.ID,
+				StaffID:       updatedAppointment.StaffID,
+				CustomerID:    updatedAppointment.CustomerID,
+				Capacity:      service.Seats(),
+				StartTime:     updatedAppointment.StartTime,
+				Duration:      duration,
+				BufferTime:    service.BufferTime,
+				AppointmentID: existingAppointment.ID,
+			})
 			if err != nil {
 				return err
 			}
-			if !available {
-				return fmt.Errorf("time slot not available")
-			}
+			updatedAppointment.StaffID = staffID
 
-			// Set updated end_time if start_time is modified
-			if isTimeUpdated {
-				updatedAppointment.EndTime = utils.ToIST(updatedAppointment.StartTime.Add(duration))
+			updatedAppointment.EndTime = updatedAppointment.StartTime.Add(duration)
+			updatedAppointment.Warnings, err = utils.CheckCustomerOverlaps(tx, utils.CustomerBooking{
+				CustomerID:    updatedAppointment.CustomerID,
+				ProviderID:    updatedAppointment.ProviderID,
+				StartTime:     updatedAppointment.StartTime,
+				EndTime:       updatedAppointment.EndTime,
+				AppointmentID: existingAppointment.ID,
+			})
+			if err != nil {
+				return err
 			}
 		}
 
-		// Preserve existing values if fields are not updated
-		if updatedAppointment.Title == "" {
-			updatedAppointment.Title = existingAppointment.Title
-		}
-		if updatedAppointment.Description == "" {
-			updatedAppointment.Description = existingAppointment.Description
-		}
-		if updatedAppointment.Status == "" {
-			updatedAppointment.Status = existingAppointment.Status
-		}
-		if updatedAppointment.ServiceID == 0 {
-			updatedAppointment.ServiceID = existingAppointment.ServiceID
-		}
-		if updatedAppointment.ProviderID == 0 {
-			updatedAppointment.ProviderID = existingAppointment.ProviderID
-		}
-		if updatedAppointment.CustomerID == 0 {
-			updatedAppointment.CustomerID = existingAppointment.CustomerID
+		// Variants and add-ons belong to the service they were chosen for, and the
+		// appointment is priced as the new service
+		if isServiceUpdated {
+			if err := tx.Where("appointment_id = ?", existingAppointment.ID).Delete(&models.AppointmentOption{}).Error; err != nil {
+				return err
+			}
+			var service models.Service
+			if err := tx.First(&service, updatedAppointment.ServiceID).Error; err != nil {
+				return fmt.Errorf("service not found")
+			}
+			settings, err := utils.LoadProviderSettings(tx, updatedAppointment.ProviderID)
+			if err != nil {
+				return err
+			}
+			updatedAppointment.SetPrice(service.Cost, service.Discount, settings.TaxRate)
 		}
+
 		// Do Not Change Status
 		updatedAppointment.Status = existingAppointment.Status
 
@@ -327,8 +455,68 @@ func UpdateAppointment(c *fiber.Ctx) error {
 		if err := tx.Model(&existingAppointment).Where("id = ?", id).Updates(updatedAppointment).Error; err != nil {
 			return err
 		}
+
+		// Keep who moved or changed the booking in its history
+		actor := requestActor(c)
+		if !updatedAppointment.StartTime.Equal(existingAppointment.StartTime) {
+			if err := models.RecordEvent(tx, models.AppointmentEvent{
+				AppointmentID: existingAppointment.ID,
+				Type:          models.EventRescheduled,
+				Field:         "start_time",
+				OldValue:      models.EventTime(existingAppointment.StartTime),
+				NewValue:      models.EventTime(updatedAppointment.StartTime),
+			}, actor); err != nil {
+				return err
+			}
+		}
+		changes := []struct{ field, old, new string }{
+			{"provider_id", fmt.Sprint(existingAppointment.ProviderID), fmt.Sprint(updatedAppointment.ProviderID)},
+			{"staff_id", optionalID(existingAppointment.StaffID), optionalID(updatedAppointment.StaffID)},
+			{"service_id", fmt.Sprint(existingAppointment.ServiceID), fmt.Sprint(updatedAppointment.ServiceID)},
+		}
+		for _, change := range changes {
+			if change.old == change.new {
+				continue
+			}
+			if err := models.RecordEvent(tx, models.AppointmentEvent{
+				AppointmentID: existingAppointment.ID,
+				Type:          models.EventUpdated,
+				Field:         change.field,
+				OldValue:      change.old,
+				NewValue:      change.new,
+			}, actor); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		if errors.Is(err, utils.ErrPolicyViolation) {
+			return policyRefused(c, err)
+		}
+		if utils.IsBookingConflict(err) {
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: utils.BookingErrorMessage(err),
+				Error:   err.Error(),
+			})
+		}
+		if utils.IsStaffError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid staff member",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to update appointment",
+			Error:   err.Error(),
+		})
+	}
+
+	// A reschedule takes over the customer's checkout hold on the new slot
+	if !updatedAppointment.StartTime.Equal(existingAppointment.StartTime) || updatedAppointment.ProviderID != existingAppointment.ProviderID {
+		utils.ConsumeSlotHold(updatedAppointment.ProviderID, updatedAppointment.CustomerID)
+	}
+
 	// find consumer and provider to send emails
 	var customer models.User
 	if err := db.DB.First(&customer, existingAppointment.CustomerID).Error; err != nil {
@@ -352,7 +540,8 @@ func UpdateAppointment(c *fiber.Ctx) error {
 			Error:   err.Error(),
 		})
 	}
-	// Send confirmation email
+	// Send confirmation email in the provider's time zone
+	loc := utils.ProviderLocation(provider.ID)
 	emailBody := fmt.Sprintf(`
 		<p>Dear %s,</p>
 		<p>Your appointment has been successfully updated.</p>	
@@ -366,7 +555,8 @@ func UpdateAppointment(c *fiber.Ctx) error {
 		</ul>
 		<p>Best regards,<br>
 		Your Appointment Management System</p>
-	`, customer.Name, updatedAppointment.Title, updatedAppointment.Description, updatedAppointment.StartTime, updatedAppointment.EndTime, service.Name, provider.Name)
+	`, customer.Name, updatedAppointment.Title, updatedAppointment.Description,
+		utils.FormatInZone(updatedAppointment.StartTime, loc), utils.FormatInZone(updatedAppointment.EndTime, loc), service.Name, provider.Name)
 	if err := utils.SendEmail(customer.Email, "Appointment Updated", emailBody); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
 			Message: "Failed to send confirmation email",
@@ -387,7 +577,8 @@ func UpdateAppointment(c *fiber.Ctx) error {
 		</ul>
 		<p>Best regards,<br>
 		Your Appointment Management System</p>
-	`, provider.Name, updatedAppointment.Title, updatedAppointment.Description, updatedAppointment.StartTime, updatedAppointment.EndTime, service.Name, customer.Name)
+	`, provider.Name, updatedAppointment.Title, updatedAppointment.Description,
+		utils.FormatInZone(updatedAppointment.StartTime, loc), utils.FormatInZone(updatedAppointment.EndTime, loc), service.Name, customer.Name)
 	if err := utils.SendEmail(provider.Email, "Appointment Updated", emailBody); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
 			Message: "Failed to send confirmation email to provider",
@@ -396,13 +587,6 @@ func UpdateAppointment(c *fiber.Ctx) error {
 	}
 	fmt.Println("Confirmation email to provider sent successfully")
 
-	if err != nil {
-		return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
-			Message: "Failed to update appointment or time slot not available",
-			Error:   err.Error(),
-		})
-	}
-
 	return c.JSON(updatedAppointment)
 }
 
@@ -455,6 +639,8 @@ func GetUpcomingAppointments(c *fiber.Ctx) error {
 	return c.JSON(appointments)
 }
 
+// CancelAppointment cancels a booking under the provider's cancellation policy. The response
+// explains which rule applied and the fee charged for a late cancellation.
 func CancelAppointment(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var appointment models.Appointment
@@ -465,25 +651,67 @@ func CancelAppointment(c *fiber.Ctx) error {
 		})
 	}
 
-	// Prevent cancellation of completed or canceled appointments
-	if appointment.Status == models.StatusCompleted || appointment.Status == models.StatusCanceled {
+	if !canManageAppointment(c, &appointment) {
+		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+			Message: "You can only cancel your own appointments",
+		})
+	}
+
+	// Only bookings the customer has not arrived for yet can be canceled
+	if appointment.Status != models.StatusPending && appointment.Status != models.StatusConfirmed {
 		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
-			Message: "Cannot cancel a completed or already canceled appointment",
+			Message: fmt.Sprintf("Cannot cancel an appointment that is %s", appointment.Status),
+		})
+	}
+
+	decision, err := utils.CheckPolicy(db.DB, &appointment, utils.PolicyCancel)
+	if err != nil {
+		if errors.Is(err, utils.ErrPolicyViolation) {
+			return policyRefused(c, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to check cancellation policy",
+			Error:   err.Error(),
 		})
 	}
 
-	// Update the status to canceled
-	appointment.Status = models.StatusCanceled
-	if err := db.DB.Save(&appointment).Error; err != nil {
+	var input struct {
+		Reason string `json:"reason"` // Kept in the appointment's history
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Failed to parse request body",
+				Error:   err.Error(),
+			})
+		}
+	}
+
+	// Update the status to canceled, with the late fee the policy charges
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := appointment.UpdateStatus(tx, models.StatusCanceled, requestActor(c), input.Reason); err != nil {
+			return err
+		}
+		return utils.RecordCancellationFee(tx, &appointment, decision, requestActor(c))
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
 			Message: "Failed to cancel appointment",
 			Error:   err.Error(),
 		})
 	}
-	return c.JSON(appointment)
+
+	// Offer the freed slot to the next customer on the waitlist
+	utils.OfferFreedSlot(&appointment)
+
+	return c.JSON(fiber.Map{
+		"appointment": appointment,
+		"policy":      decision,
+	})
 }
 
-// DeleteAppointment godoc
+// DeleteAppointment deletes a booking under the provider's cancellation policy, which treats it
+// like a cancellation
 func DeleteAppointment(c *fiber.Ctx) error {
 	id := c.Params("id")
 	var appointment models.Appointment
@@ -494,19 +722,338 @@ func DeleteAppointment(c *fiber.Ctx) error {
 		})
 	}
 
-	// Prevent deletion of completed or canceled appointments
-	if appointment.Status == models.StatusCompleted || appointment.Status == models.StatusCanceled {
+	if !canManageAppointment(c, &appointment) {
 		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
-			Message: "Cannot delete a completed or canceled appointment",
+			Message: "You can only delete your own appointments",
+		})
+	}
+
+	// Only bookings the customer has not arrived for yet can be deleted
+	if appointment.Status != models.StatusPending && appointment.Status != models.StatusConfirmed {
+		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+			Message: fmt.Sprintf("Cannot delete an appointment that is %s", appointment.Status),
+		})
+	}
+
+	decision, err := utils.CheckPolicy(db.DB, &appointment, utils.PolicyDelete)
+	if err != nil {
+		if errors.Is(err, utils.ErrPolicyViolation) {
+			return policyRefused(c, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to check cancellation policy",
+			Error:   err.Error(),
 		})
 	}
 
-	// Delete the appointment
-	if err := db.DB.Delete(&appointment).Error; err != nil {
+	// Delete the appointment, keeping the late fee on the record and the deletion in its history
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.RecordCancellationFee(tx, &appointment, decision, requestActor(c)); err != nil {
+			return err
+		}
+		if err := tx.Delete(&appointment).Error; err != nil {
+			return err
+		}
+		return models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventDeleted,
+		}, requestActor(c))
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
 			Message: "Failed to delete appointment",
 			Error:   err.Error(),
 		})
 	}
-	return c.SendStatus(fiber.StatusNoContent)
+
+	// Offer the freed slot to the next customer on the waitlist
+	utils.OfferFreedSlot(&appointment)
+
+	return c.JSON(fiber.Map{
+		"message": "Appointment deleted",
+		"policy":  decision,
+	})
+}
+
+// UpdateAppointmentSeries cancels or reschedules this, this and following, or all occurrences of a recurring appointment
+func UpdateAppointmentSeries(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var input struct {
+		Scope     string    `json:"scope"`  // "this", "following" or "all"
+		Action    string    `json:"action"` // "cancel" or "reschedule"
+		StartTime time.Time `json:"start_time"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
+			Error:   err.Error(),
+		})
+	}
+	scope, err := utils.ParseSeriesScope(input.Scope)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid scope",
+			Error:   err.Error(),
+		})
+	}
+
+	var appointment models.Appointment
+	if err := db.DB.First(&appointment, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Appointment not found",
+			Error:   err.Error(),
+		})
+	}
+	if appointment.CustomerID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+			Message: "You can only update your own appointments",
+		})
+	}
+	if appointment.Status != models.StatusPending && appointment.Status != models.StatusConfirmed {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Only pending or confirmed appointments can be changed",
+		})
+	}
+
+	// The occurrence the customer picked must be allowed by the provider's policy; every
+	// occurrence in scope is then checked and charged on its own
+	policyAction := utils.PolicyCancel
+	if input.Action == "reschedule" {
+		policyAction = utils.PolicyReschedule
+	}
+	decision, err := utils.CheckPolicy(db.DB, &appointment, policyAction)
+	if err != nil {
+		if errors.Is(err, utils.ErrPolicyViolation) {
+			return policyRefused(c, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to check cancellation policy",
+			Error:   err.Error(),
+		})
+	}
+
+	var results []utils.OccurrenceResult
+	var verb string
+	switch input.Action {
+	case "cancel":
+		verb = "canceled"
+		results, err = utils.CancelSeries(&appointment, scope, requestActor(c), true)
+	case "reschedule":
+		if input.StartTime.IsZero() || input.StartTime.Before(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "A future start_time is required to reschedule",
+			})
+		}
+		verb = "rescheduled"
+		results, err = utils.RescheduleSeries(&appointment, scope, input.StartTime.UTC(), requestActor(c), true)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid action. Use 'cancel' or 'reschedule'",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to update appointment series",
+			Error:   err.Error(),
+		})
+	}
+
+	// Let the provider know about the change
+	var provider models.User
+	if err := db.DB.First(&provider, appointment.ProviderID).Error; err == nil {
+		if err := utils.SendEmail(provider.Email, "Recurring Appointments Updated", utils.SeriesChangeEmail(provider.Name, verb, results, utils.ProviderLocation(appointment.ProviderID))); err != nil {
+			fmt.Println("Failed to send series update email to provider:", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Appointment series updated",
+		"scope":   scope,
+		"action":  input.Action,
+		"results": results,
+		"policy":  decision,
+	})
+}
+
+// GetFlaggedOccurrences lists recurring occurrences of the customer's series that could not be booked
+func GetFlaggedOccurrences(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var flags []models.RecurrenceFlag
+	if err := db.DB.Preload("Service").
+		Where("customer_id = ? AND occurrence_time > ?", userID, time.Now()).
+		Order("occurrence_time asc").
+		Find(&flags).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to fetch flagged occurrences",
+			Error:   err.Error(),
+		})
+	}
+	return c.JSON(flags)
+}
+
+// HoldSlot holds a provider slot for the customer for a few minutes while they check out
+func HoldSlot(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var request struct {
+		ProviderID uint      `json:"provider_id"`
+		ServiceID  uint      `json:"service_id"`
+		StaffID    *uint     `json:"staff_id"` // Optional, any available staff member when omitted
+		StartTime  time.Time `json:"start_time"`
+		utils.ServiceSelection
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
+			Error:   err.Error(),
+		})
+	}
+	if request.ProviderID == 0 || request.ServiceID == 0 || request.StartTime.IsZero() {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "provider_id, service_id and start_time are required",
+		})
+	}
+	if !request.StartTime.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "start_time must be in the future",
+		})
+	}
+
+	hold, err := utils.PlaceSlotHold(request.ProviderID, request.ServiceID, request.StaffID, userID, request.StartTime, request.ServiceSelection)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidOption) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid service option",
+				Error:   err.Error(),
+			})
+		}
+		if utils.IsBookingConflict(err) {
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: utils.BookingErrorMessage(err),
+				Error:   err.Error(),
+			})
+		}
+		if utils.IsStaffError(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid staff member",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to hold slot",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(hold)
+}
+
+// ReleaseSlot gives up the customer's hold on a provider's slot
+func ReleaseSlot(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+	providerID, err := c.ParamsInt("provider_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid provider ID",
+			Error:   err.Error(),
+		})
+	}
+
+	if err := utils.ReleaseSlotHold(uint(providerID), userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to release slot",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Slot hold released",
+	})
+}
+
+// policyRefused reports a change the provider's cancellation policy does not allow
+func policyRefused(c *fiber.Ctx, err error) error {
+	var policyErr *utils.PolicyError
+	if !errors.As(err, &policyErr) {
+		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+			Message: "Not allowed by the cancellation policy",
+			Error:   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"message": policyErr.Decision.Explanation,
+		"error":   err.Error(),
+		"policy":  policyErr.Decision,
+	})
+}
+
+// clearServerFields drops the fields of a posted appointment that only the server sets, so a
+// customer cannot lower their reschedule count, set their own fee, fake a series slot, join a
+// visit or stamp their own check-in or no-show. Zero values are skipped when an update is
+// saved, so the existing values are kept.
+func clearServerFields(appointment *models.Appointment) {
+	appointment.RescheduleCount = 0
+	appointment.CancellationFee = 0
+	appointment.OriginalStartTime = nil
+	// Only BookVisit links appointments to a visit
+	appointment.VisitID = nil
+	// A posted staff member would be saved as a new one and replace the reserved staff_id
+	appointment.Staff = nil
+	// Reschedule requests are only made through ProposeReschedule
+	appointment.RescheduleRequests = nil
+	// Front desk stamps are set by the provider's status transitions only
+	appointment.CheckedInAt, appointment.CheckedInBy = nil, nil
+	appointment.StartedAt, appointment.StartedBy = nil, nil
+	appointment.NoShowAt, appointment.NoShowBy = nil, nil
+}
+
+// canManageAppointment reports whether the user may cancel or delete the appointment: its
+// customer, its provider or an admin
+func canManageAppointment(c *fiber.Ctx, appointment *models.Appointment) bool {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return false
+	}
+	role, _ := c.Locals("role").(string)
+	return role == "admin" || appointment.CustomerID == userID || appointment.ProviderID == userID
+}
+
+// requestActor is the signed-in user, recorded as the author of appointment changes
+func requestActor(c *fiber.Ctx) models.Actor {
+	role, _ := c.Locals("role").(string)
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return models.Actor{Role: role}
+	}
+	return models.Actor{ID: &userID, Role: role}
+}
+
+// optionalID formats an optional ID for an appointment event, empty when unset
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprint(*id)
 }
@@ -0,0 +1,55 @@
+package consumer
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+)
+
+// GetAppointmentHistory returns every recorded change of an appointment, oldest first. The
+// customer, the provider and the provider's receptionists can see it, including for deleted
+// appointments.
+func GetAppointmentHistory(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+	role, _ := c.Locals("role").(string)
+
+	var appointment models.Appointment
+	if err := db.DB.Unscoped().First(&appointment, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Appointment not found",
+			Error:   err.Error(),
+		})
+	}
+
+	allowed := role == "admin" || appointment.CustomerID == userID || appointment.ProviderID == userID
+	if !allowed && role == "receptionist" {
+		var receptionist models.ReceptionistSettings
+		if err := db.DB.Where("receptionist_id = ?", userID).First(&receptionist).Error; err == nil {
+			allowed = receptionist.ProviderID == appointment.ProviderID
+		}
+	}
+	if !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(utils.ErrorResponse{
+			Message: "You can only view the history of your own appointments",
+		})
+	}
+
+	var events []models.AppointmentEvent
+	if err := db.DB.Where("appointment_id = ?", appointment.ID).Order("created_at asc, id asc").Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to fetch appointment history",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"appointment_id": appointment.ID,
+		"events":         events,
+	})
+}
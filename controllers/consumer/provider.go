@@ -1,13 +1,16 @@
 package consumer
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/meinhoongagan/appointment-app/db"
 	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
 )
 
 // GetAllProviders returns all service providers
@@ -55,7 +58,7 @@ func GetProviderDetails(c *fiber.Ctx) error {
 
 	var provider models.User
 	if err := db.DB.Preload("Role").
-		Preload("WorkingHours").
+		Preload("WorkingHours.Breaks").
 		First(&provider, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Provider not found",
@@ -96,7 +99,7 @@ func GetProviderServices(c *fiber.Ctx) error {
 
 	// Get provider's services
 	var services []models.Service
-	if err := db.DB.Where("provider_id = ?", id).Find(&services).Error; err != nil {
+	if err := db.DB.Preload("Options").Where("provider_id = ?", id).Find(&services).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch provider services",
 		})
@@ -105,6 +108,63 @@ func GetProviderServices(c *fiber.Ctx) error {
 	return c.JSON(services)
 }
 
+// GetProviderCancellationPolicy shows customers the provider's cancellation policy before booking
+func GetProviderCancellationPolicy(c *fiber.Ctx) error {
+	var provider models.User
+	if err := db.DB.First(&provider, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	policy, err := utils.LoadCancellationPolicy(db.DB, provider.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(policy)
+}
+
+// GetProviderStaff lists the provider's active staff members, optionally only those who
+// perform a service
+func GetProviderStaff(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	// Check if the provider exists
+	var provider models.User
+	if err := db.DB.First(&provider, id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var staff []models.StaffMember
+	if serviceID := c.Query("service_id"); serviceID != "" {
+		serviceIDUint, err := strconv.ParseUint(serviceID, 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid service ID",
+			})
+		}
+		staff, err = utils.QualifiedStaff(db.DB, provider.ID, uint(serviceIDUint))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch staff",
+			})
+		}
+	} else if err := db.DB.Where("provider_id = ? AND active = ?", provider.ID, true).Order("name asc").Find(&staff).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch staff",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"staff": staff,
+	})
+}
+
 // SearchProviders searches for providers by name, business name, or service
 func SearchProviders(c *fiber.Ctx) error {
 	query := c.Query("q")
@@ -240,21 +300,30 @@ func GetNearbyProviders(c *fiber.Ctx) error {
 
 // GetAvailableSlots returns available appointment slots for a provider on a given date
 func GetAvailableSlots(c *fiber.Ctx) error {
-	// Define IST location
-	ist, err := time.LoadLocation("Asia/Kolkata")
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to load IST timezone",
-		})
-	}
-
 	// Get query parameters
 	providerID := c.Params("provider_id")
 	dateStr := c.Query("date")         // Expected format: "YYYY-MM-DD"
 	serviceID := c.Query("service_id") // Required
 
-	// Parse the date in IST
-	date, err := time.ParseInLocation("2006-01-02", dateStr, ist)
+	providerIDUint, err := strconv.ParseUint(providerID, 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid provider ID",
+		})
+	}
+
+	// Closures, the advance booking window and the time zone apply to slots just like to bookings
+	settings, err := utils.LoadProviderSettings(db.DB, uint(providerIDUint))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	loc := utils.LoadTimeZone(settings.TimeZone)
+	now := time.Now()
+
+	// Parse the date in the provider's time zone
+	date, err := time.ParseInLocation("2006-01-02", dateStr, loc)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid date format, use YYYY-MM-DD",
@@ -274,96 +343,292 @@ func GetAvailableSlots(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get working hours for the day of the week
-	dayOfWeek := models.DayOfWeek(date.Weekday())
-	var workingHours models.WorkingHours
-	if err := db.DB.Where("provider_id = ? AND day_of_week = ?", providerID, dayOfWeek).First(&workingHours).Error; err != nil {
-		return c.JSON(fiber.Map{
-			"slots":   []string{},
-			"message": fmt.Sprintf("No working hours defined for %s", date.Weekday()),
-		})
+	// Pick the calendars to search: the requested staff member, every staff member who
+	// performs the service ("any available"), or the provider's own calendar
+	var calendars []*uint
+	if staffParam := c.Query("staff_id"); staffParam != "" {
+		staffIDUint, err := strconv.ParseUint(staffParam, 10, 32)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid staff ID",
+			})
+		}
+		staffID := uint(staffIDUint)
+		if err := utils.CheckStaffMember(db.DB, uint(providerIDUint), service.ID, staffID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		calendars = []*uint{&staffID}
+	} else {
+		staff, err := utils.QualifiedStaff(db.DB, uint(providerIDUint), service.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		for i := range staff {
+			calendars = append(calendars, &staff[i].ID)
+		}
+		if len(calendars) == 0 {
+			calendars = []*uint{nil}
+		}
 	}
 
-	// Parse working hours in IST
-	startTime, err := time.ParseInLocation("15:04", workingHours.StartTime, ist)
+	// The chosen variant and add-ons change how long each slot needs to be
+	selection, err := utils.ParseSelection(c.Query("variant_id"), c.Query("add_on_ids"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid start time format",
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
 		})
 	}
-	endTime, err := time.ParseInLocation("15:04", workingHours.EndTime, ist)
+	quote, err := utils.QuoteService(db.DB, &service, selection)
 	if err != nil {
+		if errors.Is(err, utils.ErrInvalidOption) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid end time format",
+			"error": err.Error(),
 		})
 	}
+	duration := quote.Duration
 
-	// Combine date with working hours
-	startDateTime := time.Date(date.Year(), date.Month(), date.Day(), startTime.Hour(), startTime.Minute(), 0, 0, ist)
-	endDateTime := time.Date(date.Year(), date.Month(), date.Day(), endTime.Hour(), endTime.Minute(), 0, 0, ist)
+	// Get service duration and buffer time
+	slotDuration := duration + service.BufferTime
 
-	// Handle break times if defined
-	var breakStart, breakEnd *time.Time
-	if workingHours.BreakStart != nil && workingHours.BreakEnd != nil {
-		bs, err := time.ParseInLocation("15:04", *workingHours.BreakStart, ist)
-		if err == nil {
-			bsTime := time.Date(date.Year(), date.Month(), date.Day(), bs.Hour(), bs.Minute(), 0, 0, ist)
-			breakStart = &bsTime
-		}
-		be, err := time.ParseInLocation("15:04", *workingHours.BreakEnd, ist)
-		if err == nil {
-			beTime := time.Date(date.Year(), date.Month(), date.Day(), be.Hour(), be.Minute(), 0, 0, ist)
-			breakEnd = &beTime
+	// Get existing appointments for the local date
+	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	endOfDay := startOfDay.AddDate(0, 0, 1)
+	var appointments []models.Appointment
+	if err := db.DB.Preload("Service").Where("provider_id = ? AND start_time >= ? AND start_time < ? AND status IN ?",
+		providerID, startOfDay, endOfDay, models.ActiveStatuses).
+		Find(&appointments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch appointments",
+		})
+	}
+
+	// Slots held by other customers during checkout are treated as taken
+	allHolds, err := utils.ProviderSlotHolds(uint(providerIDUint))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userID, _ := c.Locals("userID").(uint)
+	var holds []utils.SlotHold
+	for _, hold := range allHolds {
+		if hold.CustomerID != userID {
+			holds = append(holds, hold)
 		}
 	}
 
-	// Get service duration and buffer time
-	slotDuration := service.Duration + service.BufferTime
+	// Slots offered to other customers from the waitlist are taken until the offer ends
+	offers, err := utils.PendingOffers(db.DB, uint(providerIDUint), userID, startOfDay, endOfDay)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 
-	// Get existing appointments for the date in IST
-	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, ist)
-	endOfDay := startOfDay.Add(24 * time.Hour)
-	var appointments []models.Appointment
-	if err := db.DB.Where("provider_id = ? AND start_time >= ? AND start_time < ? AND status != ?",
-		providerID, startOfDay, endOfDay, models.StatusCanceled).Find(&appointments).Error; err != nil {
+	// Walk-ins being served keep their staff member busy until they are done
+	walkIns, err := utils.ServingWalkIns(db.DB, uint(providerIDUint), now)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch appointments",
+			"error": err.Error(),
 		})
 	}
 
-	// Calculate available slots
-	var availableSlots []string
-	currentSlot := startDateTime
-	for currentSlot.Add(slotDuration).Before(endDateTime) || currentSlot.Add(slotDuration).Equal(endDateTime) {
-		// Skip if slot is during break
-		if breakStart != nil && breakEnd != nil {
-			if (currentSlot.Equal(*breakStart) || currentSlot.After(*breakStart)) && currentSlot.Before(*breakEnd) {
-				currentSlot = currentSlot.Add(slotDuration)
-				continue
-			}
+	// Rooms and equipment the service needs must be free too, whoever the staff member is
+	resourceNeeds, err := utils.LoadResourceNeeds(db.DB, uint(providerIDUint))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	var resourceClaims []utils.ResourceClaim
+	if len(resourceNeeds[service.ID]) > 0 {
+		var providerServices []models.Service
+		if err := db.DB.Where("provider_id = ?", providerID).Find(&providerServices).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch services",
+			})
+		}
+		capacities := make(map[uint]int, len(providerServices))
+		for _, s := range providerServices {
+			capacities[s.ID] = s.Seats()
 		}
+		resourceClaims = append(utils.AppointmentClaims(appointments), utils.HoldClaims(holds, capacities)...)
+	}
 
-		// Check if slot is available (no overlap with appointments)
-		isAvailable := true
-		slotEnd := currentSlot.Add(slotDuration)
-		for _, appt := range appointments {
-			if (currentSlot.Before(appt.EndTime) && slotEnd.After(appt.StartTime)) ||
-				currentSlot.Equal(appt.StartTime) {
-				isAvailable = false
-				break
+	// Seats left in each available slot; one-to-one services have a single seat per
+	// staff member. StaffIDs lists who can take the slot when the provider has staff.
+	type SlotSeats struct {
+		StartTime      string `json:"start_time"`
+		Capacity       int    `json:"capacity"`
+		RemainingSeats int    `json:"remaining_seats"`
+		StaffIDs       []uint `json:"staff_ids,omitempty"`
+	}
+	capacity := service.Seats()
+
+	// Calculate available slots on every calendar and merge them by start time
+	slotsByStart := map[int64]*SlotSeats{}
+	var starts []time.Time
+	var rejection error
+	closedReason := ""
+	openCalendars := 0
+	for _, staffID := range calendars {
+		// Resolve the working hours for the date, including holidays and custom hours
+		schedule, err := utils.GetDaySchedule(uint(providerIDUint), staffID, date)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if schedule.Closed {
+			if closedReason == "" {
+				closedReason = schedule.Reason
 			}
+			continue
 		}
+		openCalendars++
+
+		for _, shift := range schedule.Shifts {
+			currentSlot := shift.Start
+			for !currentSlot.Add(duration).After(shift.End) {
+				// Skip if the appointment would run into a break
+				if !schedule.Fits(currentSlot, duration) {
+					currentSlot = currentSlot.Add(slotDuration)
+					continue
+				}
+
+				// Skip if the provider's settings would reject the booking
+				if err := utils.CheckProviderSettings(settings, currentSlot, currentSlot.Add(duration), now); err != nil {
+					rejection = err
+					currentSlot = currentSlot.Add(slotDuration)
+					continue
+				}
+
+				// Check if slot is available (no overlap with appointments or holds on this
+				// calendar). Bookings of the same group session take a seat instead of
+				// blocking the slot.
+				isAvailable := true
+				booked := 0
+				slotEnd := currentSlot.Add(slotDuration)
+				for _, appt := range appointments {
+					if !utils.SameStaff(appt.StaffID, staffID) {
+						continue
+					}
+					if capacity > 1 && appt.ServiceID == service.ID && appt.StartTime.Equal(currentSlot) {
+						booked++
+						continue
+					}
+					if (currentSlot.Before(appt.EndTime) && slotEnd.After(appt.StartTime)) ||
+						currentSlot.Equal(appt.StartTime) {
+						isAvailable = false
+						break
+					}
+				}
+
+				for _, hold := range holds {
+					if !isAvailable {
+						break
+					}
+					if !utils.SameStaff(hold.StaffID, staffID) {
+						continue
+					}
+					if capacity > 1 && hold.ServiceID == service.ID && hold.StartTime.Equal(currentSlot) {
+						booked++
+						continue
+					}
+					if hold.Overlaps(currentSlot, slotEnd) {
+						isAvailable = false
+					}
+				}
+
+				for _, offer := range offers {
+					if isAvailable && utils.SameStaff(offer.StaffID, staffID) &&
+						offer.StartTime.Before(slotEnd) && offer.EndTime.After(currentSlot) {
+						isAvailable = false
+					}
+				}
+
+				for _, walkIn := range walkIns {
+					if isAvailable && utils.SameStaff(walkIn.StaffID, staffID) &&
+						walkIn.Span.Start.Before(slotEnd) && walkIn.Span.End.After(currentSlot) {
+						isAvailable = false
+					}
+				}
+
+				if isAvailable && booked < capacity && len(resourceNeeds[service.ID]) > 0 {
+					err := resourceNeeds.Check(utils.ResourceClaim{
+						ServiceID: service.ID,
+						StaffID:   staffID,
+						Start:     currentSlot,
+						End:       currentSlot.Add(duration),
+						Session:   capacity > 1,
+					}, resourceClaims)
+					if err != nil {
+						rejection = err
+						isAvailable = false
+					}
+				}
+
+				if isAvailable && booked < capacity {
+					slot, ok := slotsByStart[currentSlot.Unix()]
+					if !ok {
+						slot = &SlotSeats{StartTime: currentSlot.Format(time.RFC3339)}
+						slotsByStart[currentSlot.Unix()] = slot
+						starts = append(starts, currentSlot)
+					}
+					slot.Capacity += capacity
+					slot.RemainingSeats += capacity - booked
+					if staffID != nil {
+						slot.StaffIDs = append(slot.StaffIDs, *staffID)
+					}
+				}
+				currentSlot = currentSlot.Add(slotDuration)
+			}
+		}
+	}
 
-		if isAvailable {
-			availableSlots = append(availableSlots, currentSlot.Format("15:04"))
+	if openCalendars == 0 {
+		message := fmt.Sprintf("No working hours defined for %s", date.Weekday())
+		if closedReason != "" {
+			message = fmt.Sprintf("Provider is not available on %s: %s", dateStr, closedReason)
 		}
-		currentSlot = currentSlot.Add(slotDuration)
+		return c.JSON(fiber.Map{
+			"slots":   []string{},
+			"message": message,
+		})
+	}
+
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	availableSlots := []string{}
+	seats := []SlotSeats{}
+	for _, start := range starts {
+		slot := slotsByStart[start.Unix()]
+		availableSlots = append(availableSlots, slot.StartTime)
+		seats = append(seats, *slot)
+	}
+
+	if len(availableSlots) == 0 && rejection != nil {
+		return c.JSON(fiber.Map{
+			"slots":   availableSlots,
+			"message": utils.BookingErrorMessage(rejection),
+		})
 	}
 
 	return c.JSON(fiber.Map{
 		"slots":       availableSlots,
+		"seats":       seats,
+		"time_zone":   loc.String(),
 		"provider_id": providerID,
 		"date":        dateStr,
 		"service_id":  serviceID,
+		"duration":    duration,
+		"price":       quote.Price,
 	})
 }
@@ -0,0 +1,62 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+)
+
+// GetMyWalkIns returns the customer's place in the walk-in queues they are in, with the
+// estimated start time worked out from how each provider's day is running right now
+func GetMyWalkIns(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var walkIns []models.WalkIn
+	if err := db.DB.Preload("Service").
+		Where("customer_id = ? AND status IN ?", userID, []models.WalkInStatus{models.WalkInWaiting, models.WalkInServing}).
+		Order("arrived_at asc").
+		Find(&walkIns).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to fetch walk-ins",
+			Error:   err.Error(),
+		})
+	}
+
+	entries := make([]utils.QueueEntry, 0, len(walkIns))
+	queues := map[uint][]utils.QueueEntry{}
+	for _, walkIn := range walkIns {
+		if walkIn.Status == models.WalkInServing {
+			entries = append(entries, utils.QueueEntry{WalkIn: walkIn, StaffID: walkIn.StaffID, EstimatedStart: walkIn.StartedAt})
+			continue
+		}
+
+		queue, ok := queues[walkIn.ProviderID]
+		if !ok {
+			var err error
+			if queue, err = utils.EstimateQueue(walkIn.ProviderID, time.Now()); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+					Message: "Failed to estimate queue",
+					Error:   err.Error(),
+				})
+			}
+			queues[walkIn.ProviderID] = queue
+		}
+		for _, entry := range queue {
+			if entry.WalkIn.ID == walkIn.ID {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"walk_ins": entries,
+	})
+}
@@ -0,0 +1,152 @@
+package consumer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
+)
+
+// ProposeReschedule asks the provider to move the customer's appointment to one of the
+// proposed times. The appointment keeps its current time until the provider decides.
+func ProposeReschedule(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+	appointmentID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid appointment ID",
+			Error:   err.Error(),
+		})
+	}
+
+	var input struct {
+		StartTimes []time.Time `json:"start_times"`
+		Note       string      `json:"note"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
+			Error:   err.Error(),
+		})
+	}
+
+	request, err := utils.ProposeReschedule(uint(appointmentID), userID, input.StartTimes, input.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+				Message: "Appointment not found",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrInvalidProposal):
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid reschedule proposal",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrRescheduleRequestOpen):
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: "A reschedule request is already waiting for the provider",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrPolicyViolation):
+			return policyRefused(c, err)
+		case utils.IsBookingConflict(err):
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: utils.BookingErrorMessage(err),
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to request reschedule",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(request)
+}
+
+// GetRescheduleRequests lists every reschedule request made for the customer's appointment
+func GetRescheduleRequests(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var appointment models.Appointment
+	if err := db.DB.Where("id = ? AND customer_id = ?", c.Params("id"), userID).First(&appointment).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Appointment not found",
+			Error:   err.Error(),
+		})
+	}
+
+	var requests []models.RescheduleRequest
+	if err := db.DB.Preload("Options").Where("appointment_id = ?", appointment.ID).
+		Order("created_at asc").Find(&requests).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to fetch reschedule requests",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"requests": requests,
+	})
+}
+
+// WithdrawRescheduleRequest takes back a reschedule request the provider has not decided yet
+func WithdrawRescheduleRequest(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+	appointmentID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid appointment ID",
+			Error:   err.Error(),
+		})
+	}
+	requestID, err := c.ParamsInt("request_id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid request ID",
+			Error:   err.Error(),
+		})
+	}
+
+	request, err := utils.WithdrawReschedule(uint(requestID), uint(appointmentID), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+				Message: "Reschedule request not found",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrRescheduleRequestClosed):
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: "Reschedule request was already decided",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to withdraw reschedule request",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(request)
+}
@@ -0,0 +1,162 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
+)
+
+// CreateVisit books several services of one provider back to back, e.g. a haircut followed
+// by a colour. All services are booked together or not at all.
+func CreateVisit(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var request struct {
+		ProviderID  uint      `json:"provider_id"`
+		StaffID     *uint     `json:"staff_id"` // Optional, any available staff member when omitted
+		StartTime   time.Time `json:"start_time"`
+		ServiceIDs  []uint    `json:"service_ids"` // In the order they are performed
+		Description string    `json:"description"`
+
+		// Variant and add-ons of each service, in the order of service_ids
+		Selections []utils.ServiceSelection `json:"selections"`
+	}
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
+			Error:   err.Error(),
+		})
+	}
+	if request.ProviderID == 0 || request.StartTime.IsZero() || len(request.ServiceIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "provider_id, start_time and service_ids are required",
+		})
+	}
+	if !request.StartTime.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "start_time must be in the future",
+		})
+	}
+
+	visit, items, err := utils.BookVisit(utils.VisitRequest{
+		ProviderID:  request.ProviderID,
+		CustomerID:  userID,
+		StaffID:     request.StaffID,
+		StartTime:   request.StartTime,
+		ServiceIDs:  request.ServiceIDs,
+		Selections:  request.Selections,
+		Description: request.Description,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrVisitService):
+			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+				Message: "Service not found or does not belong to provider",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrInvalidOption):
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid service option",
+				Error:   err.Error(),
+			})
+		case utils.IsBookingConflict(err):
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: utils.BookingErrorMessage(err),
+				Error:   err.Error(),
+			})
+		case utils.IsStaffError(err):
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid staff member",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to book visit",
+			Error:   err.Error(),
+		})
+	}
+
+	// The booking takes over the customer's checkout hold
+	utils.ConsumeSlotHold(visit.ProviderID, visit.CustomerID)
+
+	var customer, provider models.User
+	if err := db.DB.First(&customer, visit.CustomerID).Error; err == nil {
+		if err := db.DB.First(&provider, visit.ProviderID).Error; err == nil {
+			loc := utils.ProviderLocation(visit.ProviderID)
+			intro := fmt.Sprintf("Your visit with %s has been booked.", provider.Name)
+			if err := utils.SendEmail(customer.Email, "Visit Confirmation", visitEmail(customer.Name, intro, visit, items, loc)); err != nil {
+				fmt.Println("Failed to send visit confirmation email:", err)
+			}
+			// The provider gets the same itemized list of the services to prepare
+			intro = fmt.Sprintf("%s has booked a visit with you.", customer.Name)
+			if err := utils.SendEmail(provider.Email, "New Visit Booked", visitEmail(provider.Name, intro, visit, items, loc)); err != nil {
+				fmt.Println("Failed to send visit email to provider:", err)
+			}
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"visit": visit,
+		"items": items,
+		"total": visit.TotalPrice,
+	})
+}
+
+// GetVisit returns one of the customer's visits with its itemized price
+func GetVisit(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var visit models.Visit
+	if err := db.DB.Preload("Appointments", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("start_time asc")
+	}).Preload("Appointments.Service").
+		Where("id = ? AND customer_id = ?", c.Params("id"), userID).
+		First(&visit).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Visit not found",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"visit": visit,
+		"items": utils.VisitItems(visit.Appointments),
+		"total": visit.TotalPrice,
+	})
+}
+
+// visitEmail lists the services of a visit with their times in the provider's time zone
+func visitEmail(name, intro string, visit *models.Visit, items []utils.VisitItem, loc *time.Location) string {
+	var rows strings.Builder
+	for _, item := range items {
+		rows.WriteString(fmt.Sprintf("<li><strong>%s:</strong> %s - %s, %.2f</li>",
+			item.Name, utils.FormatInZone(item.StartTime, loc), utils.FormatInZone(item.EndTime, loc), item.Price))
+	}
+	return fmt.Sprintf(`
+		<p>Dear %s,</p>
+		<p>%s</p>
+		<p><strong>Services:</strong></p>
+		<ul>%s</ul>
+		<p><strong>Total:</strong> %.2f</p>
+		<p>Thank you for choosing our service!</p>
+		<p>Best regards,</p>
+		<p>Your Appointment Team</p>
+	`, name, intro, rows.String(), visit.TotalPrice)
+}
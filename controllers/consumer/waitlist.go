@@ -0,0 +1,258 @@
+package consumer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
+)
+
+// JoinWaitlist adds the customer to the waitlist for a provider's service within a date range
+func JoinWaitlist(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var entry models.WaitlistEntry
+	if err := c.BodyParser(&entry); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
+			Error:   err.Error(),
+		})
+	}
+	if entry.FromDate.IsZero() || entry.ToDate.IsZero() || !entry.ToDate.After(entry.FromDate) {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "from_date and to_date are required and to_date must be after from_date",
+		})
+	}
+	if !entry.ToDate.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "to_date must be in the future",
+		})
+	}
+
+	var service models.Service
+	if err := db.DB.Where("id = ? AND provider_id = ?", entry.ServiceID, entry.ProviderID).First(&service).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Service not found or does not belong to provider",
+			Error:   err.Error(),
+		})
+	}
+
+	// Offered slots are sized for the variant and add-ons the customer wants
+	var selection utils.ServiceSelection
+	if err := c.BodyParser(&selection); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Failed to parse request body",
+			Error:   err.Error(),
+		})
+	}
+	quote, err := utils.QuoteService(db.DB, &service, selection)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidOption) {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid service option",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to load service options",
+			Error:   err.Error(),
+		})
+	}
+	if entry.StaffID != nil {
+		if err := utils.CheckStaffMember(db.DB, entry.ProviderID, entry.ServiceID, *entry.StaffID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+				Message: "Invalid staff member",
+				Error:   err.Error(),
+			})
+		}
+	}
+
+	entry = models.WaitlistEntry{
+		CustomerID: userID,
+		ProviderID: entry.ProviderID,
+		ServiceID:  entry.ServiceID,
+		StaffID:    entry.StaffID,
+		FromDate:   entry.FromDate.UTC(),
+		ToDate:     entry.ToDate.UTC(),
+		Status:     models.WaitlistWaiting,
+		Options:    utils.WaitlistOptions(quote),
+	}
+	if err := db.DB.Create(&entry).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to join waitlist",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
+
+// GetMyWaitlist lists the customer's waitlist entries and their pending offers
+func GetMyWaitlist(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var entries []models.WaitlistEntry
+	if err := db.DB.Preload("Service").Preload("Options").
+		Where("customer_id = ? AND status IN ?", userID, []models.WaitlistStatus{models.WaitlistWaiting, models.WaitlistOffered}).
+		Order("created_at asc").
+		Find(&entries).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to fetch waitlist",
+			Error:   err.Error(),
+		})
+	}
+
+	var offers []models.WaitlistOffer
+	if err := db.DB.Where("customer_id = ? AND status = ? AND expires_at > ?", userID, models.OfferPending, time.Now()).
+		Order("expires_at asc").
+		Find(&offers).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to fetch waitlist offers",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"entries": entries,
+		"offers":  offers,
+	})
+}
+
+// LeaveWaitlist removes the customer from the waitlist and releases any pending offer
+func LeaveWaitlist(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var entry models.WaitlistEntry
+	if err := db.DB.Where("id = ? AND customer_id = ?", c.Params("id"), userID).First(&entry).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Waitlist entry not found",
+			Error:   err.Error(),
+		})
+	}
+	if entry.Status == models.WaitlistBooked || entry.Status == models.WaitlistCanceled {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Waitlist entry is already closed",
+		})
+	}
+
+	if err := db.DB.Model(&entry).Update("status", models.WaitlistCanceled).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to leave waitlist",
+			Error:   err.Error(),
+		})
+	}
+
+	// Pass a slot currently held for the customer to the next person
+	var offer models.WaitlistOffer
+	if err := db.DB.Where("entry_id = ? AND status = ?", entry.ID, models.OfferPending).First(&offer).Error; err == nil {
+		if err := utils.CloseWaitlistOffer(&offer, models.OfferDeclined); err != nil && !errors.Is(err, utils.ErrOfferNotPending) {
+			return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+				Message: "Failed to release waitlist offer",
+				Error:   err.Error(),
+			})
+		}
+	}
+
+	return c.JSON(entry)
+}
+
+// AcceptWaitlistOffer books the slot held for the customer
+func AcceptWaitlistOffer(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+	offerID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(utils.ErrorResponse{
+			Message: "Invalid offer ID",
+			Error:   err.Error(),
+		})
+	}
+
+	appointment, err := utils.AcceptWaitlistOffer(uint(offerID), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+				Message: "Offer not found",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrOfferNotPending):
+			return c.Status(fiber.StatusGone).JSON(utils.ErrorResponse{
+				Message: "Offer is no longer available",
+				Error:   err.Error(),
+			})
+		case errors.Is(err, utils.ErrInvalidOption):
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: "The chosen options are no longer offered",
+				Error:   err.Error(),
+			})
+		case utils.IsBookingConflict(err):
+			return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
+				Message: utils.BookingErrorMessage(err),
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to accept offer",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(appointment)
+}
+
+// DeclineWaitlistOffer gives up the held slot so it passes to the next customer
+func DeclineWaitlistOffer(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(utils.ErrorResponse{
+			Message: "Invalid user ID in token",
+		})
+	}
+
+	var offer models.WaitlistOffer
+	if err := db.DB.Where("id = ? AND customer_id = ?", c.Params("id"), userID).First(&offer).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
+			Message: "Offer not found",
+			Error:   err.Error(),
+		})
+	}
+
+	if err := utils.CloseWaitlistOffer(&offer, models.OfferDeclined); err != nil {
+		if errors.Is(err, utils.ErrOfferNotPending) {
+			return c.Status(fiber.StatusGone).JSON(utils.ErrorResponse{
+				Message: "Offer is no longer available",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
+			Message: "Failed to decline offer",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(offer)
+}
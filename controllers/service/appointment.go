@@ -8,6 +8,7 @@ import (
 	"github.com/meinhoongagan/appointment-app/db"
 	"github.com/meinhoongagan/appointment-app/models"
 	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
 )
 
 func GetAllAppointments(c *fiber.Ctx) error {
@@ -42,6 +43,10 @@ func GetAllAppointments(c *fiber.Ctx) error {
 	if appointmentStatus != "" {
 		query = query.Where("status = ?", appointmentStatus)
 	}
+	// Narrow down to one practitioner's calendar
+	if staffID := c.Query("staff_id"); staffID != "" {
+		query = query.Preload("Staff").Where("staff_id = ?", staffID)
+	}
 
 	if err := query.Find(&appointments).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -61,7 +66,7 @@ func GetAppointmentDetails(c *fiber.Ctx) error {
 	}
 
 	var appointment models.Appointment
-	if err := db.DB.Preload("Service").Preload("Provider").Preload("Customer").First(&appointment, appointmentID).Error; err != nil {
+	if err := db.DB.Preload("Service").Preload("Provider").Preload("Customer").Preload("Options").First(&appointment, appointmentID).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(utils.ErrorResponse{
 			Message: "Appointment not found",
 			Error:   err.Error(),
@@ -140,7 +145,7 @@ func GetProviderUpcomingAppointments(c *fiber.Ctx) error {
 		Where("provider_id = ?", userID).
 		Where("start_time >= ?", startDate).
 		Where("start_time <= ?", endDate).
-		Where("status IN ?", []models.AppointmentStatus{models.StatusPending, models.StatusConfirmed})
+		Where("status IN ?", models.ActiveStatuses)
 
 	// Sort by start time
 	query = query.Order("start_time asc")
@@ -219,12 +224,16 @@ func GetProviderAppointmentHistory(c *fiber.Ctx) error {
 			statuses = []models.AppointmentStatus{models.StatusCompleted}
 		case models.StatusCanceled:
 			statuses = []models.AppointmentStatus{models.StatusCanceled}
+		case models.StatusNoShow:
+			statuses = []models.AppointmentStatus{models.StatusNoShow}
+		case models.StatusExpired:
+			statuses = []models.AppointmentStatus{models.StatusExpired}
 		default:
-			statuses = []models.AppointmentStatus{models.StatusCompleted, models.StatusCanceled}
+			statuses = []models.AppointmentStatus{models.StatusCompleted, models.StatusCanceled, models.StatusNoShow, models.StatusExpired}
 		}
 	} else {
-		// Default: show both completed and canceled
-		statuses = []models.AppointmentStatus{models.StatusCompleted, models.StatusCanceled}
+		// Default: show completed, canceled, no-show and expired appointments
+		statuses = []models.AppointmentStatus{models.StatusCompleted, models.StatusCanceled, models.StatusNoShow, models.StatusExpired}
 	}
 
 	// Parse optional date range
@@ -263,6 +272,27 @@ func GetProviderAppointmentHistory(c *fiber.Ctx) error {
 
 	countQuery.Count(&total)
 
+	// Count each outcome on its own so no-shows are not mixed up with cancellations
+	var outcomes []struct {
+		Status models.AppointmentStatus
+		Count  int64
+	}
+	outcomeQuery := db.DB.Model(&models.Appointment{}).
+		Select("status, COUNT(*) as count").
+		Where("provider_id = ?", userID).
+		Where("status IN ?", statuses)
+	if dateRange != "all" {
+		outcomeQuery = outcomeQuery.Where("end_time >= ? AND end_time <= ?", startDate, endDate)
+	}
+	outcomeQuery.Group("status").Scan(&outcomes)
+	counts := fiber.Map{}
+	for _, s := range statuses {
+		counts[string(s)] = int64(0)
+	}
+	for _, outcome := range outcomes {
+		counts[string(outcome.Status)] = outcome.Count
+	}
+
 	// Query for appointment history
 	query := db.DB.
 		Preload("Service").
@@ -294,10 +324,12 @@ func GetProviderAppointmentHistory(c *fiber.Ctx) error {
 		"pages":        (total + int64(limit) - 1) / int64(limit), // Ceiling division
 		"range":        dateRange,
 		"status":       status,
+		"counts":       counts,
 	})
 }
 
-// UpdateAppointmentStatus updates the status of an appointment (accept/reject)
+// UpdateAppointmentStatus updates the status of an appointment: accept/reject, and check-in,
+// start, completion or no-show at the front desk
 func UpdateAppointmentStatus(c *fiber.Ctx) error {
 	// Get the authenticated user ID from context
 	userID, ok := c.Locals("userID").(uint)
@@ -326,6 +358,7 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 	// Parse request body
 	var updateData struct {
 		Status string `json:"status"`
+		Reason string `json:"reason"` // Kept in the appointment's history
 	}
 
 	if err := c.BodyParser(&updateData); err != nil {
@@ -336,11 +369,12 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 
 	// Validate status value
 	newStatus := models.AppointmentStatus(updateData.Status)
-	if newStatus != models.StatusConfirmed &&
-		newStatus != models.StatusCanceled &&
-		newStatus != models.StatusCompleted {
+	switch newStatus {
+	case models.StatusConfirmed, models.StatusCanceled, models.StatusCompleted,
+		models.StatusCheckedIn, models.StatusInProgress, models.StatusNoShow:
+	default:
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid status. Must be 'confirmed', 'canceled', or 'completed'.",
+			"error": "Invalid status. Must be 'confirmed', 'canceled', 'completed', 'checked_in', 'in_progress' or 'no_show'.",
 		})
 	}
 
@@ -369,13 +403,33 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 		}
 	}
 
+	// A customer can only miss an appointment once it has started
+	if newStatus == models.StatusNoShow && time.Now().Before(appointment.StartTime) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot mark a no-show before the appointment starts",
+		})
+	}
+
 	// Update the status
-	if err := appointment.UpdateStatus(db.DB, newStatus); err != nil {
+	if err := appointment.UpdateStatus(db.DB, newStatus, models.Actor{ID: &userID, Role: role}, updateData.Reason); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	// The customer is at the front desk, so check-ins and starts need no email
+	if newStatus == models.StatusCheckedIn || newStatus == models.StatusInProgress {
+		return c.JSON(fiber.Map{
+			"message":     "Appointment status updated successfully",
+			"appointment": appointment,
+		})
+	}
+
+	// Offer the freed slot to the next customer on the waitlist
+	if newStatus == models.StatusCanceled {
+		utils.OfferFreedSlot(&appointment)
+	}
+
 	// find provider and customer and send email
 	var provider models.User
 	if err := db.DB.First(&provider, appointment.ProviderID).Error; err != nil {
@@ -389,6 +443,7 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 			"error": "Customer not found",
 		})
 	}
+	loc := utils.ProviderLocation(appointment.ProviderID)
 	emailBody := `
 		<!DOCTYPE html>
 		<html>
@@ -413,7 +468,8 @@ func UpdateAppointmentStatus(c *fiber.Ctx) error {
 		</body>
 		</html>
 			`
-	emailBody = fmt.Sprintf(emailBody, customer.Name, provider.Name, newStatus, appointment.Service.Name, appointment.StartTime.Format("2006-01-02 15:04"), appointment.EndTime.Format("2006-01-02 15:04"), newStatus)
+	emailBody = fmt.Sprintf(emailBody, customer.Name, provider.Name, newStatus, appointment.Service.Name,
+		utils.FormatInZone(appointment.StartTime, loc), utils.FormatInZone(appointment.EndTime, loc), newStatus)
 	if err := utils.SendEmail(customer.Email, "Appointment Status Update", emailBody); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to send email notification",
@@ -462,6 +518,7 @@ func RescheduleAppointment(c *fiber.Ctx) error {
 	// Parse request body
 	var rescheduleData struct {
 		StartTime string `json:"start_time"`
+		Reason    string `json:"reason"` // Kept in the appointment's history
 	}
 
 	if err := c.BodyParser(&rescheduleData); err != nil {
@@ -501,7 +558,6 @@ func RescheduleAppointment(c *fiber.Ctx) error {
 			"error": "Service not found",
 		})
 	}
-	endTime := startTime.Add(time.Duration(service.Duration) * time.Minute)
 
 	// Check if the provider owns this appointment
 	if appointment.ProviderID != userID && role != "admin" {
@@ -526,53 +582,71 @@ func RescheduleAppointment(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check for scheduling conflicts
-	var conflictCount int64
-	db.DB.Model(&models.Appointment{}).
-		Where("provider_id = ? AND id != ?", userID, appointmentID).
-		Where("status IN ?", []models.AppointmentStatus{models.StatusPending, models.StatusConfirmed}).
-		Where("(start_time < ? AND end_time > ?) OR (start_time >= ? AND start_time < ?)",
-			endTime, startTime, startTime, endTime).
-		Count(&conflictCount)
-
-	if conflictCount > 0 {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "The requested time slot conflicts with existing appointments",
-		})
-	}
+	// Reserve the new slot and move the appointment in a single transaction
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.ReserveSlot(tx, utils.SlotRequest{
+			ProviderID:    appointment.ProviderID,
+			ServiceID:     service.ID,
+			StaffID:       appointment.StaffID,
+			CustomerID:    appointment.CustomerID,
+			Capacity:      service.Seats(),
+			StartTime:     startTime,
+			Duration:      appointment.Duration(),
+			BufferTime:    service.BufferTime,
+			AppointmentID: appointment.ID,
+		}); err != nil {
+			return err
+		}
+		warnings, err := utils.CheckCustomerOverlaps(tx, utils.CustomerBooking{
+			CustomerID:    appointment.CustomerID,
+			ProviderID:    appointment.ProviderID,
+			StartTime:     startTime,
+			EndTime:       startTime.Add(appointment.Duration()),
+			AppointmentID: appointment.ID,
+		})
+		if err != nil {
+			return err
+		}
 
-	// Update the appointment times
-	appointment.StartTime = startTime
-	duration := service.Duration
-	total_duration := duration + service.BufferTime
-	isWorkingHour, err := utils.CheckWorkingDayAndHours(appointment.ProviderID, appointment.StartTime)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(utils.ErrorResponse{
-			Message: "Error checking working hours",
-			Error:   err.Error(),
-		})
-	}
-	// Check if the appointment is during break time
-	fmt.Println("Checking break time...")
-	if !isWorkingHour {
-		return c.Status(fiber.StatusConflict).JSON(utils.ErrorResponse{
-			Message: "Appointment is outside working hours or during break",
-		})
-	}
-	available, err := utils.CheckAvailability(appointment.ProviderID, appointment.StartTime, total_duration)
+		// Update the appointment times, stored in UTC; the length includes any add-ons
+		previous := appointment
+		appointment.EndTime = startTime.Add(appointment.Duration()).UTC()
+		appointment.StartTime = startTime.UTC()
+		appointment.Status = models.StatusPending
+		if err := tx.Save(&appointment).Error; err != nil {
+			return err
+		}
+		appointment.Warnings = warnings
+
+		actor := models.Actor{ID: &userID, Role: role}
+		if err := models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventRescheduled,
+			Field:         "start_time",
+			OldValue:      models.EventTime(previous.StartTime),
+			NewValue:      models.EventTime(appointment.StartTime),
+			Reason:        rescheduleData.Reason,
+		}, actor); err != nil {
+			return err
+		}
+		if previous.Status == appointment.Status {
+			return nil
+		}
+		return models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventStatusChanged,
+			Field:         "status",
+			OldValue:      string(previous.Status),
+			NewValue:      string(appointment.Status),
+			Reason:        "Rescheduled by the provider",
+		}, actor)
+	})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to check availability",
-		})
-	}
-	if !available {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
-			"error": "The requested time slot conflicts with existing appointments",
-		})
-	}
-	appointment.EndTime = startTime.Add(time.Duration(service.Duration) * time.Minute)
-	appointment.Status = models.StatusPending
-	if err := db.DB.Save(&appointment).Error; err != nil {
+		if utils.IsBookingConflict(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to reschedule appointment",
 		})
@@ -590,6 +664,7 @@ func RescheduleAppointment(c *fiber.Ctx) error {
 			"error": "Customer not found",
 		})
 	}
+	loc := utils.ProviderLocation(appointment.ProviderID)
 	emailBody := `
 		<!DOCTYPE html>
 		<html>
@@ -602,8 +677,8 @@ func RescheduleAppointment(c *fiber.Ctx) error {
 			<h1>Appointment Rescheduled</h1>
 			<p>Dear ` + customer.Name + `,</p>
 			<p>Your appointment with ` + provider.Name + ` has been rescheduled to the following times:</p>
-			<p>Start Time: ` + appointment.StartTime.Format("2006-01-02 15:04:05") + `</p>
-			<p>End Time: ` + appointment.EndTime.Format("2006-01-02 15:04:05") + `</p>
+			<p>Start Time: ` + utils.FormatInZone(appointment.StartTime, loc) + `</p>
+			<p>End Time: ` + utils.FormatInZone(appointment.EndTime, loc) + `</p>
 			<p>Best regards,<br>` + provider.Name + `</p>
 		</body>
 		</html>
@@ -622,3 +697,264 @@ func RescheduleAppointment(c *fiber.Ctx) error {
 		"appointment": appointment,
 	})
 }
+
+// UpdateAppointmentSeries cancels or reschedules this, this and following, or all occurrences of a recurring appointment
+func UpdateAppointmentSeries(c *fiber.Ctx) error {
+	// Get the authenticated user ID from context
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+
+	// Get user role
+	role, ok := c.Locals("role").(string)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User role not found in context",
+		})
+	}
+
+	// Get appointment ID from URL
+	appointmentID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid appointment ID",
+		})
+	}
+
+	// Parse request body
+	var seriesData struct {
+		Scope     string `json:"scope"`  // "this", "following" or "all"
+		Action    string `json:"action"` // "cancel" or "reschedule"
+		StartTime string `json:"start_time"`
+	}
+	if err := c.BodyParser(&seriesData); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	scope, err := utils.ParseSeriesScope(seriesData.Scope)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Find the appointment
+	var appointment models.Appointment
+	if err := db.DB.First(&appointment, appointmentID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Appointment not found",
+		})
+	}
+
+	// Check if the provider owns this appointment
+	if appointment.ProviderID != userID && role != "admin" {
+		var provider models.ReceptionistSettings
+		if err := db.DB.First(&provider, "receptionist_id = ?", userID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Provider not found",
+			})
+		}
+		if appointment.ProviderID != provider.ProviderID {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You can only update your own appointments",
+			})
+		}
+	}
+
+	if appointment.Status != models.StatusPending && appointment.Status != models.StatusConfirmed {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Only pending or confirmed appointments can be changed",
+		})
+	}
+
+	var results []utils.OccurrenceResult
+	var verb string
+	switch seriesData.Action {
+	case "cancel":
+		verb = "canceled"
+		results, err = utils.CancelSeries(&appointment, scope, models.Actor{ID: &userID, Role: role}, false)
+	case "reschedule":
+		startTime, parseErr := time.Parse(time.RFC3339, seriesData.StartTime)
+		if parseErr != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid start time format. Please use RFC3339 format.",
+			})
+		}
+		if startTime.Before(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot schedule an appointment in the past",
+			})
+		}
+		verb = "rescheduled"
+		results, err = utils.RescheduleSeries(&appointment, scope, startTime, models.Actor{ID: &userID, Role: role}, false)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid action. Must be 'cancel' or 'reschedule'.",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update appointment series: " + err.Error(),
+		})
+	}
+
+	// Let the customer know about the change
+	var customer models.User
+	if err := db.DB.First(&customer, appointment.CustomerID).Error; err == nil {
+		if err := utils.SendEmail(customer.Email, "Recurring Appointments Updated", utils.SeriesChangeEmail(customer.Name, verb, results, utils.ProviderLocation(appointment.ProviderID))); err != nil {
+			fmt.Println("Failed to send series update email to customer:", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Appointment series updated",
+		"scope":   scope,
+		"action":  seriesData.Action,
+		"results": results,
+	})
+}
+
+// GetFlaggedOccurrences lists recurring occurrences that could not be booked for the logged-in provider
+func GetFlaggedOccurrences(c *fiber.Ctx) error {
+	// Get the authenticated user ID from context
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+
+	var flags []models.RecurrenceFlag
+	if err := db.DB.Preload("Service").
+		Where("provider_id = ? AND occurrence_time > ?", userID, time.Now()).
+		Order("occurrence_time asc").
+		Find(&flags).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"flagged_occurrences": flags,
+		"count":               len(flags),
+	})
+}
+
+// providerIDFor returns the provider whose calendar the user manages; receptionists act for their provider
+func providerIDFor(userID uint, role string) (uint, error) {
+	if role != "receptionist" {
+		return userID, nil
+	}
+	var settings models.ReceptionistSettings
+	if err := db.DB.First(&settings, "receptionist_id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+	return settings.ProviderID, nil
+}
+
+// GetSessionAttendees lists the sessions on a date with their attendees and remaining seats
+func GetSessionAttendees(c *fiber.Ctx) error {
+	// Get the authenticated user ID from context
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	role, _ := c.Locals("role").(string)
+
+	providerID, err := providerIDFor(userID, role)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	// Parse the date in the provider's time zone
+	loc := utils.ProviderLocation(providerID)
+	date, err := time.ParseInLocation("2006-01-02", c.Query("date"), loc)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid date format, use YYYY-MM-DD",
+		})
+	}
+
+	query := db.DB.Preload("Service").Preload("Customer").
+		Where("provider_id = ? AND start_time >= ? AND start_time < ? AND status IN ?",
+			providerID, date, date.AddDate(0, 0, 1),
+			append([]models.AppointmentStatus{models.StatusCompleted}, models.ActiveStatuses...))
+	if serviceID := c.Query("service_id"); serviceID != "" {
+		query = query.Where("service_id = ?", serviceID)
+	}
+
+	var appointments []models.Appointment
+	if err := query.Order("start_time asc, id asc").Find(&appointments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	type Attendee struct {
+		AppointmentID uint                     `json:"appointment_id"`
+		CustomerID    uint                     `json:"customer_id"`
+		Name          string                   `json:"name"`
+		Email         string                   `json:"email"`
+		Status        models.AppointmentStatus `json:"status"`
+	}
+	type Session struct {
+		ServiceID      uint       `json:"service_id"`
+		ServiceName    string     `json:"service_name"`
+		StartTime      time.Time  `json:"start_time"`
+		EndTime        time.Time  `json:"end_time"`
+		Capacity       int        `json:"capacity"`
+		Booked         int        `json:"booked"`
+		RemainingSeats int        `json:"remaining_seats"`
+		Attendees      []Attendee `json:"attendees"`
+	}
+
+	// Group bookings of the same service and start time into sessions
+	sessions := make([]*Session, 0)
+	index := make(map[string]*Session)
+	for _, appt := range appointments {
+		key := fmt.Sprintf("%d-%d", appt.ServiceID, appt.StartTime.Unix())
+		session, exists := index[key]
+		if !exists {
+			session = &Session{
+				ServiceID:   appt.ServiceID,
+				ServiceName: appt.Service.Name,
+				StartTime:   appt.StartTime.In(loc),
+				EndTime:     appt.EndTime.In(loc),
+				Capacity:    appt.Service.Seats(),
+				Attendees:   []Attendee{},
+			}
+			index[key] = session
+			sessions = append(sessions, session)
+		}
+		session.Attendees = append(session.Attendees, Attendee{
+			AppointmentID: appt.ID,
+			CustomerID:    appt.CustomerID,
+			Name:          appt.Customer.Name,
+			Email:         appt.Customer.Email,
+			Status:        appt.Status,
+		})
+		if appt.Status != models.StatusCompleted {
+			session.Booked++
+		}
+	}
+	for _, session := range sessions {
+		session.RemainingSeats = session.Capacity - session.Booked
+		if session.RemainingSeats < 0 {
+			session.RemainingSeats = 0
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"date":      c.Query("date"),
+		"time_zone": loc.String(),
+		"sessions":  sessions,
+	})
+}
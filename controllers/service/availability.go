@@ -0,0 +1,296 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
+)
+
+// validateAvailabilityOverride checks the dates, custom hours and breaks of an override
+// and defaults the end date to the start date for single-day overrides
+func validateAvailabilityOverride(o *models.AvailabilityOverride) error {
+	startDate, err := time.Parse("2006-01-02", o.StartDate)
+	if err != nil {
+		return fmt.Errorf("invalid start_date: must be YYYY-MM-DD")
+	}
+	if o.EndDate == "" {
+		o.EndDate = o.StartDate
+	}
+	endDate, err := time.Parse("2006-01-02", o.EndDate)
+	if err != nil {
+		return fmt.Errorf("invalid end_date: must be YYYY-MM-DD")
+	}
+	if endDate.Before(startDate) {
+		return fmt.Errorf("end_date must not be before start_date")
+	}
+
+	if o.IsClosed {
+		if o.StartTime != nil || o.EndTime != nil || len(o.Breaks) > 0 {
+			return fmt.Errorf("a closed day cannot have custom hours or breaks")
+		}
+		return nil
+	}
+
+	if (o.StartTime != nil) != (o.EndTime != nil) {
+		return fmt.Errorf("both start_time and end_time must be provided or omitted")
+	}
+	if o.StartTime == nil && len(o.Breaks) == 0 {
+		return fmt.Errorf("an override must close the day, set custom hours or add breaks")
+	}
+
+	var openTime, closeTime time.Time
+	if o.StartTime != nil {
+		openTime, err = time.Parse("15:04", *o.StartTime)
+		if err != nil {
+			return fmt.Errorf("invalid start_time: must be HH:MM")
+		}
+		closeTime, err = time.Parse("15:04", *o.EndTime)
+		if err != nil {
+			return fmt.Errorf("invalid end_time: must be HH:MM")
+		}
+		if !closeTime.After(openTime) {
+			return fmt.Errorf("end_time must be after start_time")
+		}
+	}
+
+	for i, b := range o.Breaks {
+		breakStart, err := time.Parse("15:04", b.StartTime)
+		if err != nil {
+			return fmt.Errorf("invalid break start_time at index %d: must be HH:MM", i)
+		}
+		breakEnd, err := time.Parse("15:04", b.EndTime)
+		if err != nil {
+			return fmt.Errorf("invalid break end_time at index %d: must be HH:MM", i)
+		}
+		if !breakEnd.After(breakStart) {
+			return fmt.Errorf("break end_time must be after start_time at index %d", i)
+		}
+		if o.StartTime != nil && (breakStart.Before(openTime) || breakEnd.After(closeTime)) {
+			return fmt.Errorf("break at index %d must be within the custom hours", i)
+		}
+	}
+
+	return nil
+}
+
+// findAffectedAppointments returns the active appointments inside the override's dates
+// that no longer fall within the provider's working hours
+func findAffectedAppointments(providerID uint, o *models.AvailabilityOverride) ([]models.Appointment, error) {
+	loc := utils.ProviderLocation(providerID)
+	from, err := time.ParseInLocation("2006-01-02", o.StartDate, loc)
+	if err != nil {
+		return nil, err
+	}
+	to, err := time.ParseInLocation("2006-01-02", o.EndDate, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var appointments []models.Appointment
+	if err := db.DB.Preload("Customer").Preload("Service").
+		Where("provider_id = ? AND status IN ? AND start_time >= ? AND start_time < ?",
+			providerID, models.ActiveStatuses,
+			from, to.AddDate(0, 0, 1)).
+		Order("start_time asc").
+		Find(&appointments).Error; err != nil {
+		return nil, err
+	}
+
+	affected := []models.Appointment{}
+	for _, appt := range appointments {
+		isWorkingHour, err := utils.CheckWorkingDayAndHours(providerID, appt.StaffID, appt.StartTime.In(loc), appt.EndTime.Sub(appt.StartTime))
+		if err != nil {
+			return nil, err
+		}
+		if !isWorkingHour {
+			affected = append(affected, appt)
+		}
+	}
+	return affected, nil
+}
+
+// GetAvailabilityOverrides lists the provider's holidays, time off and custom hours
+func GetAvailabilityOverrides(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	query := db.DB.Preload("Breaks").Where("provider_id = ?", userID)
+	if from := c.Query("from"); from != "" {
+		query = query.Where("end_date >= ?", from)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("start_date <= ?", to)
+	}
+
+	var overrides []models.AvailabilityOverride
+	if err := query.Order("start_date asc").Find(&overrides).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve availability overrides",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"overrides": overrides,
+	})
+}
+
+// CreateAvailabilityOverride closes the provider or changes their hours on a range of dates
+func CreateAvailabilityOverride(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	var override models.AvailabilityOverride
+	if err := c.BodyParser(&override); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if err := validateAvailabilityOverride(&override); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if override.StaffID != nil {
+		if _, err := findStaffMember(userID, *override.StaffID); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Staff member not found",
+			})
+		}
+	}
+
+	override.ID = 0
+	override.ProviderID = userID
+	for i := range override.Breaks {
+		override.Breaks[i].ID = 0
+	}
+
+	if err := db.DB.Create(&override).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create availability override: " + err.Error(),
+		})
+	}
+
+	affected, err := findAffectedAppointments(userID, &override)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check existing appointments: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":               "Availability override created successfully",
+		"override":              override,
+		"affected_appointments": affected,
+	})
+}
+
+// UpdateAvailabilityOverride replaces the dates, hours and breaks of an override
+func UpdateAvailabilityOverride(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	overrideID := c.Params("id")
+
+	var override models.AvailabilityOverride
+	if err := db.DB.Where("id = ? AND provider_id = ?", overrideID, userID).First(&override).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Availability override not found",
+		})
+	}
+
+	var input models.AvailabilityOverride
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if err := validateAvailabilityOverride(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if input.StaffID != nil {
+		if _, err := findStaffMember(userID, *input.StaffID); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Staff member not found",
+			})
+		}
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&override).Select("StartDate", "EndDate", "IsClosed", "StartTime", "EndTime", "Reason", "StaffID").
+			Updates(models.AvailabilityOverride{
+				StartDate: input.StartDate,
+				EndDate:   input.EndDate,
+				IsClosed:  input.IsClosed,
+				StartTime: input.StartTime,
+				EndTime:   input.EndTime,
+				Reason:    input.Reason,
+				StaffID:   input.StaffID,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to update override: %v", err)
+		}
+
+		// Replace the breaks
+		if err := tx.Where("override_id = ?", override.ID).Delete(&models.AvailabilityBreak{}).Error; err != nil {
+			return fmt.Errorf("failed to delete breaks: %v", err)
+		}
+		for _, b := range input.Breaks {
+			brk := models.AvailabilityBreak{OverrideID: override.ID, StartTime: b.StartTime, EndTime: b.EndTime}
+			if err := tx.Create(&brk).Error; err != nil {
+				return fmt.Errorf("failed to create break: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update availability override: " + err.Error(),
+		})
+	}
+
+	db.DB.Preload("Breaks").First(&override, override.ID)
+
+	affected, err := findAffectedAppointments(userID, &override)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check existing appointments: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":               "Availability override updated successfully",
+		"override":              override,
+		"affected_appointments": affected,
+	})
+}
+
+// DeleteAvailabilityOverride restores the weekly working hours on the override's dates
+func DeleteAvailabilityOverride(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+	overrideID := c.Params("id")
+
+	var override models.AvailabilityOverride
+	if err := db.DB.Where("id = ? AND provider_id = ?", overrideID, userID).First(&override).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Availability override not found",
+		})
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("override_id = ?", override.ID).Delete(&models.AvailabilityBreak{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&override).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete availability override: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Availability override deleted successfully",
+	})
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+)
+
+// BulkUpdateAppointments cancels or shifts every pending and confirmed appointment in a period,
+// for example when the provider or a staff member falls ill. The period is either a date in
+// the provider's time zone or a from/to range. It returns a report of every appointment.
+func BulkUpdateAppointments(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	role, _ := c.Locals("role").(string)
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var input struct {
+		Date          string    `json:"date"` // "YYYY-MM-DD", instead of from and to
+		From          time.Time `json:"from"`
+		To            time.Time `json:"to"`
+		StaffID       *uint     `json:"staff_id"`
+		Action        string    `json:"action"`        // "cancel" or "shift"
+		ShiftMinutes  int       `json:"shift_minutes"` // Negative moves appointments earlier
+		CancelUnmoved bool      `json:"cancel_unmoved"`
+		Reason        string    `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if input.Date != "" {
+		day, err := time.ParseInLocation("2006-01-02", input.Date, utils.ProviderLocation(providerID))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid date format. Use YYYY-MM-DD",
+			})
+		}
+		input.From, input.To = day, day.AddDate(0, 0, 1)
+	}
+	if input.StaffID != nil {
+		if _, err := findStaffMember(providerID, *input.StaffID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid staff member",
+			})
+		}
+	}
+
+	report, err := utils.ApplyBulkChange(utils.BulkChange{
+		ProviderID:    providerID,
+		StaffID:       input.StaffID,
+		From:          input.From,
+		To:            input.To,
+		Action:        utils.BulkAction(input.Action),
+		ShiftBy:       time.Duration(input.ShiftMinutes) * time.Minute,
+		CancelUnmoved: input.CancelUnmoved,
+		Reason:        input.Reason,
+		Actor:         models.Actor{ID: &userID, Role: role},
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidBulkChange) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update appointments: " + err.Error(),
+		})
+	}
+
+	return c.JSON(report)
+}
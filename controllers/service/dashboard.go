@@ -6,6 +6,8 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/meinhoongagan/appointment-app/db"
 	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
 )
 
 func GetDashboardOverview(c *fiber.Ctx) error {
@@ -31,6 +33,7 @@ func GetDashboardOverview(c *fiber.Ctx) error {
 		ConfirmedCount    int64     `json:"confirmed_count"`
 		CompletedCount    int64     `json:"completed_count"`
 		CanceledCount     int64     `json:"canceled_count"`
+		NoShowCount       int64     `json:"no_show_count"`
 		TotalServices     int64     `json:"total_services"`
 		TotalRevenue      float64   `json:"total_revenue"`
 		LastUpdated       time.Time `json:"last_updated"`
@@ -51,6 +54,9 @@ func GetDashboardOverview(c *fiber.Ctx) error {
 	}
 	// Admin sees all data, so no additional filtering needed
 
+	// A new session per count keeps the status conditions from piling up on the base query
+	appointmentQuery = appointmentQuery.Session(&gorm.Session{})
+
 	// Get total appointments
 	appointmentQuery.Count(&statistics.TotalAppointments)
 
@@ -59,11 +65,13 @@ func GetDashboardOverview(c *fiber.Ctx) error {
 	appointmentQuery.Where("status = ?", models.StatusConfirmed).Count(&statistics.ConfirmedCount)
 	appointmentQuery.Where("status = ?", models.StatusCompleted).Count(&statistics.CompletedCount)
 	appointmentQuery.Where("status = ?", models.StatusCanceled).Count(&statistics.CanceledCount)
+	appointmentQuery.Where("status = ?", models.StatusNoShow).Count(&statistics.NoShowCount)
 
 	// Get total services
 	serviceQuery.Count(&statistics.TotalServices)
 
-	// Calculate total revenue (from completed appointments)
+	// Calculate total revenue (from completed appointments) from the amounts recorded at
+	// booking time, so later service price changes do not rewrite it
 	type RevenueResult struct {
 		TotalRevenue float64
 	}
@@ -71,8 +79,7 @@ func GetDashboardOverview(c *fiber.Ctx) error {
 
 	// Revenue query
 	revenueQuery := db.DB.Table("appointments").
-		Joins("JOIN services ON appointments.service_id = services.id").
-		Where("appointments.status = ?", models.StatusCompleted)
+		Where("appointments.status = ? AND appointments.deleted_at IS NULL", models.StatusCompleted)
 
 	// Filter by provider if needed
 	if role == "provider" {
@@ -81,7 +88,7 @@ func GetDashboardOverview(c *fiber.Ctx) error {
 		revenueQuery = revenueQuery.Where("appointments.customer_id = ?", userID)
 	}
 
-	revenueQuery.Select("SUM(services.cost) as total_revenue").Scan(&revenueResult)
+	revenueQuery.Select("COALESCE(SUM(appointments.total_amount), 0) as total_revenue").Scan(&revenueResult)
 	statistics.TotalRevenue = revenueResult.TotalRevenue
 
 	// Set last updated time
@@ -189,35 +196,48 @@ func GetRevenueSummary(c *fiber.Ctx) error {
 
 	// Structure to hold revenue data
 	type RevenueData struct {
-		Date     string  `json:"date"`
-		Revenue  float64 `json:"revenue"`
-		Count    int     `json:"count"`
-		Services int     `json:"services"`
+		Date      string  `json:"date"`
+		Revenue   float64 `json:"revenue"`
+		ListPrice float64 `json:"list_price"`
+		Discounts float64 `json:"discounts"`
+		Tax       float64 `json:"tax"`
+		Count     int     `json:"count"`
+		Services  int     `json:"services"`
 	}
 
 	var result []struct {
-		Date    time.Time
-		Revenue float64
-		Count   int
+		Date      time.Time
+		Revenue   float64
+		ListPrice float64
+		Discounts float64
+		Tax       float64
+		Count     int
+		Services  int
 	}
 
-	// Base query
+	// Base query. Appointments are bucketed by the calendar date in their provider's time zone,
+	// and amounts are the ones recorded on each appointment at booking time.
 	query := `
 		SELECT 
-			DATE(appointments.start_time) as date,
-			SUM(services.cost) as revenue,
-			COUNT(*) as count
+			DATE(appointments.start_time AT TIME ZONE COALESCE(NULLIF(provider_settings.time_zone, ''), ?)) as date,
+			SUM(appointments.total_amount) as revenue,
+			SUM(appointments.list_price) as list_price,
+			SUM(appointments.discount_amount) as discounts,
+			SUM(appointments.tax_amount) as tax,
+			COUNT(*) as count,
+			COUNT(DISTINCT appointments.service_id) as services
 		FROM 
 			appointments
-		JOIN 
-			services ON appointments.service_id = services.id
+		LEFT JOIN 
+			provider_settings ON provider_settings.provider_id = appointments.provider_id AND provider_settings.deleted_at IS NULL
 		WHERE 
 			appointments.status = 'completed' AND
+			appointments.deleted_at IS NULL AND
 			appointments.start_time BETWEEN ? AND ?
 	`
 
 	// Add role-based filtering
-	params := []interface{}{startDate, endDate}
+	params := []interface{}{utils.DefaultTimeZone, startDate, endDate}
 
 	if role == "provider" {
 		query += " AND appointments.provider_id = ?"
@@ -230,7 +250,7 @@ func GetRevenueSummary(c *fiber.Ctx) error {
 	// Finish the query
 	query += `
 		GROUP BY 
-			DATE(appointments.start_time)
+			1
 		ORDER BY 
 			date ASC
 	`
@@ -243,50 +263,26 @@ func GetRevenueSummary(c *fiber.Ctx) error {
 	}
 
 	// Calculate totals
-	var totalRevenue float64
+	var totalRevenue, totalDiscounts, totalTax float64
 	var totalAppointments int
 	revenueData := make([]RevenueData, 0)
 
 	for _, r := range result {
-		// Format date as string
-		dateStr := r.Date.Format("2006-01-02")
-
-		// Base query for services count
-		servicesQuery := `
-			SELECT 
-				COUNT(DISTINCT service_id) 
-			FROM 
-				appointments 
-			WHERE 
-				DATE(start_time) = ? AND
-				status = 'completed'
-		`
-
-		// Add role-based filtering for services count
-		servicesParams := []interface{}{dateStr}
-
-		if role == "provider" {
-			servicesQuery += " AND provider_id = ?"
-			servicesParams = append(servicesParams, userID)
-		} else if role != "admin" {
-			servicesQuery += " AND customer_id = ?"
-			servicesParams = append(servicesParams, userID)
-		}
-
-		// Get services count for this date
-		var servicesCount int
-		db.DB.Raw(servicesQuery, servicesParams...).Scan(&servicesCount)
-
 		// Add to revenue data array
 		revenueData = append(revenueData, RevenueData{
-			Date:     dateStr,
-			Revenue:  r.Revenue,
-			Count:    r.Count,
-			Services: servicesCount,
+			Date:      r.Date.Format("2006-01-02"),
+			Revenue:   r.Revenue,
+			ListPrice: r.ListPrice,
+			Discounts: r.Discounts,
+			Tax:       r.Tax,
+			Count:     r.Count,
+			Services:  r.Services,
 		})
 
 		// Add to totals
 		totalRevenue += r.Revenue
+		totalDiscounts += r.Discounts
+		totalTax += r.Tax
 		totalAppointments += r.Count
 	}
 
@@ -295,6 +291,8 @@ func GetRevenueSummary(c *fiber.Ctx) error {
 		"data": revenueData,
 		"summary": fiber.Map{
 			"total_revenue":      totalRevenue,
+			"total_discounts":    totalDiscounts,
+			"total_tax":          totalTax,
 			"total_appointments": totalAppointments,
 			"time_range":         timeRange,
 			"start_date":         startDate.Format("2006-01-02"),
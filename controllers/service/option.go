@@ -0,0 +1,169 @@
+package service
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+)
+
+// validateServiceOption checks an option before it is saved
+func validateServiceOption(option *models.ServiceOption) string {
+	switch option.Kind {
+	case models.OptionVariant:
+		if option.Duration <= 0 {
+			return "A variant needs a duration"
+		}
+	case models.OptionAddOn:
+		if option.Duration < 0 {
+			return "Duration cannot be negative"
+		}
+	default:
+		return "Kind must be variant or add_on"
+	}
+	if option.Name == "" {
+		return "Name is required"
+	}
+	if option.Cost < 0 {
+		return "Cost cannot be negative"
+	}
+	return ""
+}
+
+// managedService loads a service of the provider the user manages
+func managedService(c *fiber.Ctx) (*models.Service, error) {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return nil, err
+	}
+	var service models.Service
+	if err := db.DB.Where("id = ? AND provider_id = ?", c.Params("id"), providerID).First(&service).Error; err != nil {
+		return nil, err
+	}
+	return &service, nil
+}
+
+// GetServiceOptions lists the variants and add-ons of a service
+func GetServiceOptions(c *fiber.Ctx) error {
+	var options []models.ServiceOption
+	if err := db.DB.Where("service_id = ?", c.Params("id")).Order("kind, id").Find(&options).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch service options: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"options": options,
+	})
+}
+
+// CreateServiceOption adds a variant or add-on to one of the provider's services
+func CreateServiceOption(c *fiber.Ctx) error {
+	service, err := managedService(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service not found",
+		})
+	}
+
+	var option models.ServiceOption
+	if err := c.BodyParser(&option); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if msg := validateServiceOption(&option); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	option.ID = 0
+	option.ServiceID = service.ID
+	if err := db.DB.Create(&option).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create service option: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(option)
+}
+
+// UpdateServiceOption changes a variant or add-on. Appointments already booked keep the
+// duration and price they were booked with.
+func UpdateServiceOption(c *fiber.Ctx) error {
+	service, err := managedService(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service not found",
+		})
+	}
+
+	var option models.ServiceOption
+	if err := db.DB.Where("id = ? AND service_id = ?", c.Params("option_id"), service.ID).First(&option).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service option not found",
+		})
+	}
+
+	var input models.ServiceOption
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	// The kind of an option is fixed, other fields are kept unless sent
+	updated := option
+	if input.Name != "" {
+		updated.Name = input.Name
+	}
+	if input.Duration != 0 {
+		updated.Duration = input.Duration
+	}
+	if input.Cost != 0 {
+		updated.Cost = input.Cost
+	}
+	if msg := validateServiceOption(&updated); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	if err := db.DB.Model(&option).Updates(map[string]interface{}{
+		"name":     updated.Name,
+		"duration": updated.Duration,
+		"cost":     updated.Cost,
+	}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update service option: " + err.Error(),
+		})
+	}
+
+	db.DB.First(&option, option.ID)
+	return c.JSON(option)
+}
+
+// DeleteServiceOption stops offering a variant or add-on
+func DeleteServiceOption(c *fiber.Ctx) error {
+	service, err := managedService(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service not found",
+		})
+	}
+
+	result := db.DB.Where("id = ? AND service_id = ?", c.Params("option_id"), service.ID).Delete(&models.ServiceOption{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete service option: " + result.Error.Error(),
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service option not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Service option deleted successfully",
+	})
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+)
+
+// GetCancellationPolicy returns the provider's cancellation policy; an empty policy allows
+// customers to cancel and reschedule at any time
+func GetCancellationPolicy(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	policy, err := utils.LoadCancellationPolicy(db.DB, providerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(policy)
+}
+
+// UpdateCancellationPolicy replaces the provider's cancellation policy
+func UpdateCancellationPolicy(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var input models.CancellationPolicy
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if input.MinNoticeHours < 0 || input.MaxReschedules < 0 || input.NoCancelHours < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "min_notice_hours, max_reschedules and no_cancel_hours cannot be negative",
+		})
+	}
+	if input.LateCancelFeePercent < 0 || input.LateCancelFeePercent > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "late_cancel_fee_percent must be between 0 and 100",
+		})
+	}
+
+	policy, err := utils.LoadCancellationPolicy(db.DB, providerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	policy.MinNoticeHours = input.MinNoticeHours
+	policy.LateCancelFeePercent = input.LateCancelFeePercent
+	policy.MaxReschedules = input.MaxReschedules
+	policy.NoCancelHours = input.NoCancelHours
+	if err := db.DB.Save(policy).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save cancellation policy: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Cancellation policy updated successfully",
+		"policy":  policy,
+	})
+}
@@ -405,6 +405,32 @@ func UpdateProviderSettings(c *fiber.Ctx) error {
 	// Ensure provider ID is set correctly
 	updatedSettings.ProviderID = userID
 
+	// Working hours and slots are interpreted in this zone, so it must be a valid IANA name
+	if updatedSettings.TimeZone != "" {
+		if _, err := time.LoadLocation(updatedSettings.TimeZone); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid time_zone: must be an IANA time zone such as Europe/London",
+			})
+		}
+	}
+
+	// Tax is charged as a percentage of the discounted price of each booking
+	if updatedSettings.TaxRate < 0 || updatedSettings.TaxRate > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid tax_rate: must be a percentage between 0 and 100",
+		})
+	}
+	if updatedSettings.ConfirmationDeadlineHours < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid confirmation_deadline_hours: cannot be negative",
+		})
+	}
+	if updatedSettings.MaxDailyAppointments < 0 || updatedSettings.MaxCustomerBookings < 0 || updatedSettings.MinCustomerGapMinutes < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid booking limits: max_daily_appointments, max_customer_bookings and min_customer_gap_minutes cannot be negative",
+		})
+	}
+
 	// If settings exist, update them
 	if result.RowsAffected > 0 {
 		if err := db.DB.Model(&settings).Updates(updatedSettings).Error; err != nil {
@@ -435,7 +461,7 @@ func GetWorkingHours(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(uint)
 
 	var workingHours []models.WorkingHours
-	if err := db.DB.Where("provider_id = ?", userID).Find(&workingHours).Error; err != nil {
+	if err := db.DB.Preload("Breaks").Where("provider_id = ? AND staff_id IS NULL", userID).Order("day_of_week, start_time").Find(&workingHours).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to retrieve working hours",
 		})
@@ -453,93 +479,140 @@ func GetWorkingHours(c *fiber.Ctx) error {
 	})
 }
 
-func CreateWorkingHours(c *fiber.Ctx) error {
-	userID := c.Locals("userID").(uint)
-
-	// Parse input
-	var inputHours []models.WorkingHours
-	if err := c.BodyParser(&inputHours); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid input: " + err.Error(),
-		})
-	}
-
-	// Validate input
-	if len(inputHours) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "At least one working hours entry is required",
-		})
+// validateWorkingHours checks the day, shift times and breaks of each working interval.
+// A day may have several intervals as long as they do not overlap.
+func validateWorkingHours(inputHours []models.WorkingHours) error {
+	type interval struct {
+		start, end time.Time
+		index      int
 	}
+	shiftsByDay := make(map[models.DayOfWeek][]interval)
 
-	// Check for duplicate days and validate times
-	daySet := make(map[models.DayOfWeek]bool)
 	for i, wh := range inputHours {
 		// Validate day_of_week
 		if wh.DayOfWeek < models.Sunday || wh.DayOfWeek > models.Saturday {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Invalid day_of_week at index %d: must be 0-6", i),
-			})
-		}
-		if daySet[wh.DayOfWeek] {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Duplicate day_of_week %d at index %d", wh.DayOfWeek, i),
-			})
+			return fmt.Errorf("Invalid day_of_week at index %d: must be 0-6", i)
 		}
-		daySet[wh.DayOfWeek] = true
 
 		// Validate start_time and end_time
 		startTime, err := time.Parse("15:04", wh.StartTime)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Invalid start_time at index %d: must be HH:MM", i),
-			})
+			return fmt.Errorf("Invalid start_time at index %d: must be HH:MM", i)
 		}
 		endTime, err := time.Parse("15:04", wh.EndTime)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Invalid end_time at index %d: must be HH:MM", i),
-			})
+			return fmt.Errorf("Invalid end_time at index %d: must be HH:MM", i)
 		}
 		if !endTime.After(startTime) {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("end_time must be after start_time at index %d", i),
-			})
+			return fmt.Errorf("end_time must be after start_time at index %d", i)
+		}
+
+		// Shifts on the same day must not overlap
+		for _, other := range shiftsByDay[wh.DayOfWeek] {
+			if startTime.Before(other.end) && endTime.After(other.start) {
+				return fmt.Errorf("Working hours at index %d overlap index %d on day_of_week %d", i, other.index, wh.DayOfWeek)
+			}
 		}
+		shiftsByDay[wh.DayOfWeek] = append(shiftsByDay[wh.DayOfWeek], interval{startTime, endTime, i})
 
 		// Validate break times if provided
+		breaks := make([]models.WorkingBreak, 0, len(wh.Breaks)+1)
 		if wh.BreakStart != nil && wh.BreakEnd != nil {
-			breakStart, err := time.Parse("15:04", *wh.BreakStart)
+			breaks = append(breaks, models.WorkingBreak{StartTime: *wh.BreakStart, EndTime: *wh.BreakEnd})
+		} else if (wh.BreakStart != nil) != (wh.BreakEnd != nil) {
+			return fmt.Errorf("Both break_start and break_end must be provided or omitted at index %d", i)
+		}
+		breaks = append(breaks, wh.Breaks...)
+
+		for _, b := range breaks {
+			breakStart, err := time.Parse("15:04", b.StartTime)
 			if err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Invalid break_start at index %d: must be HH:MM", i),
-				})
+				return fmt.Errorf("Invalid break start time at index %d: must be HH:MM", i)
 			}
-			breakEnd, err := time.Parse("15:04", *wh.BreakEnd)
+			breakEnd, err := time.Parse("15:04", b.EndTime)
 			if err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Invalid break_end at index %d: must be HH:MM", i),
-				})
+				return fmt.Errorf("Invalid break end time at index %d: must be HH:MM", i)
 			}
 			if !breakStart.After(startTime) || !breakEnd.After(breakStart) || !endTime.After(breakEnd) {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Invalid break times at index %d: must be within working hours", i),
-				})
+				return fmt.Errorf("Invalid break times at index %d: must be within working hours", i)
 			}
-		} else if (wh.BreakStart != nil) != (wh.BreakEnd != nil) {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Both break_start and break_end must be provided or omitted at index %d", i),
-			})
 		}
 	}
 
+	return nil
+}
+
+// replaceWorkingHours swaps the weekly schedule of the provider, or of one of their staff
+// members when staffID is set, for inputHours
+func replaceWorkingHours(tx *gorm.DB, providerID uint, staffID *uint, inputHours []models.WorkingHours) error {
+	var existingIDs []uint
+	if err := tx.Model(&models.WorkingHours{}).Where("provider_id = ? AND staff_id IS NOT DISTINCT FROM ?", providerID, staffID).
+		Pluck("id", &existingIDs).Error; err != nil {
+		return fmt.Errorf("failed to fetch existing working hours: %v", err)
+	}
+	if len(existingIDs) > 0 {
+		if err := tx.Where("working_hours_id IN ?", existingIDs).Delete(&models.WorkingBreak{}).Error; err != nil {
+			return fmt.Errorf("failed to delete existing breaks: %v", err)
+		}
+		if err := tx.Where("id IN ?", existingIDs).Delete(&models.WorkingHours{}).Error; err != nil {
+			return fmt.Errorf("failed to delete existing working hours: %v", err)
+		}
+	}
+	if len(inputHours) == 0 {
+		return nil
+	}
+
+	for i := range inputHours {
+		inputHours[i].ID = 0
+		inputHours[i].ProviderID = providerID
+		inputHours[i].StaffID = staffID
+		for j := range inputHours[i].Breaks {
+			inputHours[i].Breaks[j].ID = 0
+		}
+	}
+	if err := tx.Create(&inputHours).Error; err != nil {
+		return fmt.Errorf("failed to create working hours: %v", err)
+	}
+	return nil
+}
+
+func CreateWorkingHours(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(uint)
+
+	// Parse input
+	var inputHours []models.WorkingHours
+	if err := c.BodyParser(&inputHours); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+
+	// Validate input
+	if len(inputHours) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "At least one working hours entry is required",
+		})
+	}
+
+	if err := validateWorkingHours(inputHours); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// Set provider ID
 	for i := range inputHours {
+		inputHours[i].ID = 0
 		inputHours[i].ProviderID = userID
+		inputHours[i].StaffID = nil
+		for j := range inputHours[i].Breaks {
+			inputHours[i].Breaks[j].ID = 0
+		}
 	}
 
 	// Check if working hours already exist
 	var existingHours []models.WorkingHours
-	if err := db.DB.Where("provider_id = ?", userID).Find(&existingHours).Error; err != nil {
+	if err := db.DB.Where("provider_id = ? AND staff_id IS NULL", userID).Find(&existingHours).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to check existing working hours",
 		})
@@ -559,7 +632,7 @@ func CreateWorkingHours(c *fiber.Ctx) error {
 
 	// Retrieve created working hours
 	var createdHours []models.WorkingHours
-	if err := db.DB.Where("provider_id = ?", userID).Find(&createdHours).Error; err != nil {
+	if err := db.DB.Preload("Breaks").Where("provider_id = ? AND staff_id IS NULL", userID).Order("day_of_week, start_time").Find(&createdHours).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to retrieve created working hours",
 		})
@@ -590,112 +663,15 @@ func UpdateWorkingHours(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check for duplicate days and validate times
-	daySet := make(map[models.DayOfWeek]bool)
-	for i, wh := range inputHours {
-		// Validate day_of_week
-		if wh.DayOfWeek < models.Sunday || wh.DayOfWeek > models.Saturday {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Invalid day_of_week at index %d: must be 0-6", i),
-			})
-		}
-		if daySet[wh.DayOfWeek] {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Duplicate day_of_week %d at index %d", wh.DayOfWeek, i),
-			})
-		}
-		daySet[wh.DayOfWeek] = true
-
-		// Validate start_time and end_time
-		startTime, err := time.Parse("15:04", wh.StartTime)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Invalid start_time at index %d: must be HH:MM", i),
-			})
-		}
-		endTime, err := time.Parse("15:04", wh.EndTime)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Invalid end_time at index %d: must be HH:MM", i),
-			})
-		}
-		if !endTime.After(startTime) {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("end_time must be after start_time at index %d", i),
-			})
-		}
-
-		// Validate break times if provided
-		if wh.BreakStart != nil && wh.BreakEnd != nil {
-			breakStart, err := time.Parse("15:04", *wh.BreakStart)
-			if err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Invalid break_start at index %d: must be HH:MM", i),
-				})
-			}
-			breakEnd, err := time.Parse("15:04", *wh.BreakEnd)
-			if err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Invalid break_end at index %d: must be HH:MM", i),
-				})
-			}
-			if !breakStart.After(startTime) || !breakEnd.After(breakStart) || !endTime.After(breakEnd) {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Invalid break times at index %d: must be within working hours", i),
-				})
-			}
-		} else if (wh.BreakStart != nil) != (wh.BreakEnd != nil) {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": fmt.Sprintf("Both break_start and break_end must be provided or omitted at index %d", i),
-			})
-		}
+	if err := validateWorkingHours(inputHours); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
 
-	// Perform updates, creates, and deletes in a transaction
+	// Replace the weekly schedule in a transaction
 	err := db.DB.Transaction(func(tx *gorm.DB) error {
-		// Fetch existing working hours
-		var existingHours []models.WorkingHours
-		if err := tx.Where("provider_id = ?", userID).Find(&existingHours).Error; err != nil {
-			return fmt.Errorf("failed to fetch existing working hours: %v", err)
-		}
-
-		// Create a map of existing hours by day_of_week for quick lookup
-		existingMap := make(map[models.DayOfWeek]models.WorkingHours)
-		for _, h := range existingHours {
-			existingMap[h.DayOfWeek] = h
-		}
-
-		// Process input hours
-		for _, input := range inputHours {
-			input.ProviderID = userID
-			if existing, exists := existingMap[input.DayOfWeek]; exists {
-				// Update existing record
-				if err := tx.Model(&existing).Updates(models.WorkingHours{
-					StartTime:  input.StartTime,
-					EndTime:    input.EndTime,
-					BreakStart: input.BreakStart,
-					BreakEnd:   input.BreakEnd,
-				}).Error; err != nil {
-					return fmt.Errorf("failed to update working hours for day %d: %v", input.DayOfWeek, err)
-				}
-			} else {
-				// Create new record
-				if err := tx.Create(&input).Error; err != nil {
-					return fmt.Errorf("failed to create working hours for day %d: %v", input.DayOfWeek, err)
-				}
-			}
-			// Remove from existingMap to track which days remain
-			delete(existingMap, input.DayOfWeek)
-		}
-
-		// Delete any remaining existing hours not in the input
-		for day := range existingMap {
-			if err := tx.Where("provider_id = ? AND day_of_week = ?", userID, day).Delete(&models.WorkingHours{}).Error; err != nil {
-				return fmt.Errorf("failed to delete working hours for day %d: %v", day, err)
-			}
-		}
-
-		return nil
+		return replaceWorkingHours(tx, userID, nil, inputHours)
 	})
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -705,7 +681,7 @@ func UpdateWorkingHours(c *fiber.Ctx) error {
 
 	// Retrieve updated working hours
 	var workingHours []models.WorkingHours
-	if err := db.DB.Where("provider_id = ?", userID).Find(&workingHours).Error; err != nil {
+	if err := db.DB.Preload("Breaks").Where("provider_id = ? AND staff_id IS NULL", userID).Order("day_of_week, start_time").Find(&workingHours).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to retrieve updated working hours: " + err.Error(),
 		})
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+)
+
+// walkInInput is the body accepted when adding a walk-in to the queue
+type walkInInput struct {
+	ServiceID  uint   `json:"service_id"`
+	StaffID    *uint  `json:"staff_id"`    // Requested staff member, omit for any available
+	CustomerID *uint  `json:"customer_id"` // Registered customer, omit for a guest
+	Name       string `json:"name"`
+	Phone      string `json:"phone"`
+}
+
+// queueResponse lists the walk-ins being served and the waiting queue with fresh estimates
+func queueResponse(c *fiber.Ctx, providerID uint) error {
+	queue, err := utils.EstimateQueue(providerID, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to estimate queue: " + err.Error(),
+		})
+	}
+
+	var serving []models.WalkIn
+	if err := db.DB.Preload("Service").
+		Where("provider_id = ? AND status = ?", providerID, models.WalkInServing).
+		Order("started_at asc").
+		Find(&serving).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch walk-ins: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"serving": serving,
+		"queue":   queue,
+	})
+}
+
+// GetWalkInQueue returns the provider's walk-in queue with estimated start times
+func GetWalkInQueue(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+	return queueResponse(c, providerID)
+}
+
+// AddWalkIn puts a customer who arrived without a booking at the back of the queue
+func AddWalkIn(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var input walkInInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+
+	var service models.Service
+	if err := db.DB.Where("id = ? AND provider_id = ?", input.ServiceID, providerID).First(&service).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service not found",
+		})
+	}
+	if input.StaffID != nil {
+		if err := utils.CheckStaffMember(db.DB, providerID, service.ID, *input.StaffID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid staff member",
+			})
+		}
+	}
+
+	// Registered customers can follow their place in the queue themselves
+	if input.CustomerID != nil {
+		var customer models.User
+		if err := db.DB.First(&customer, *input.CustomerID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		if input.Name == "" {
+			input.Name = customer.Name
+		}
+	}
+	if input.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required for a guest walk-in",
+		})
+	}
+
+	walkIn := models.WalkIn{
+		ProviderID: providerID,
+		ServiceID:  service.ID,
+		StaffID:    input.StaffID,
+		CustomerID: input.CustomerID,
+		Name:       input.Name,
+		Phone:      input.Phone,
+		Status:     models.WalkInWaiting,
+		AddedBy:    userID,
+		ArrivedAt:  time.Now(),
+	}
+	if err := db.DB.Create(&walkIn).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to add walk-in: " + err.Error(),
+		})
+	}
+
+	queue, err := utils.EstimateQueue(providerID, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to estimate queue: " + err.Error(),
+		})
+	}
+	for _, entry := range queue {
+		if entry.WalkIn.ID == walkIn.ID {
+			return c.Status(fiber.StatusCreated).JSON(entry)
+		}
+	}
+	return c.Status(fiber.StatusCreated).JSON(utils.QueueEntry{WalkIn: walkIn})
+}
+
+// UpdateWalkInStatus starts serving a walk-in, finishes them, or records that they left.
+// A walk-in who did not ask for a staff member is served by the one named in the body, or
+// by the staff member the queue estimate assigned them to.
+func UpdateWalkInStatus(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var input struct {
+		Status  string `json:"status"`
+		StaffID *uint  `json:"staff_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	newStatus := models.WalkInStatus(input.Status)
+	switch newStatus {
+	case models.WalkInServing, models.WalkInDone, models.WalkInLeft:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid status. Must be 'serving', 'done' or 'left'.",
+		})
+	}
+
+	var walkIn models.WalkIn
+	if err := db.DB.Where("id = ? AND provider_id = ?", c.Params("id"), providerID).First(&walkIn).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Walk-in not found",
+		})
+	}
+
+	if newStatus == models.WalkInServing {
+		if input.StaffID != nil {
+			if err := utils.CheckStaffMember(db.DB, providerID, walkIn.ServiceID, *input.StaffID); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid staff member",
+				})
+			}
+			walkIn.StaffID = input.StaffID
+		} else if walkIn.StaffID == nil {
+			queue, err := utils.EstimateQueue(providerID, time.Now())
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to estimate queue: " + err.Error(),
+				})
+			}
+			for _, entry := range queue {
+				if entry.WalkIn.ID == walkIn.ID {
+					walkIn.StaffID = entry.StaffID
+				}
+			}
+		}
+	}
+
+	if err := walkIn.UpdateStatus(db.DB, newStatus); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return queueResponse(c, providerID)
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
+)
+
+// GetRescheduleRequests lists the customers' reschedule requests for the provider, pending ones
+// by default; ?status=all returns every request
+func GetRescheduleRequests(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	query := db.DB.Preload("Options").Where("provider_id = ?", providerID)
+	switch status := c.Query("status", string(models.ReschedulePending)); status {
+	case "all":
+	case string(models.ReschedulePending), string(models.RescheduleAccepted), string(models.RescheduleDeclined),
+		string(models.RescheduleWithdrawn), string(models.RescheduleExpired):
+		query = query.Where("status = ?", status)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid status filter",
+		})
+	}
+
+	var requests []models.RescheduleRequest
+	if err := query.Order("created_at asc").Find(&requests).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch reschedule requests: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"requests": requests,
+	})
+}
+
+// AcceptRescheduleRequest moves the appointment to the proposed time chosen by option_id
+func AcceptRescheduleRequest(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+	requestID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request ID",
+		})
+	}
+
+	var input struct {
+		OptionID uint `json:"option_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	role, _ := c.Locals("role").(string)
+	appointment, request, err := utils.AcceptReschedule(uint(requestID), input.OptionID, providerID, models.Actor{ID: &userID, Role: role})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Reschedule request not found",
+			})
+		case errors.Is(err, utils.ErrInvalidProposal):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case errors.Is(err, utils.ErrRescheduleRequestClosed):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   err.Error(),
+				"request": request,
+			})
+		case errors.Is(err, utils.ErrPolicyViolation):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		case utils.IsBookingConflict(err):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": utils.BookingErrorMessage(err),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to accept reschedule request: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"appointment": appointment,
+		"request":     request,
+	})
+}
+
+// DeclineRescheduleRequest turns the request down; the appointment keeps its current time
+func DeclineRescheduleRequest(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "User ID not found in context",
+		})
+	}
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+	requestID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request ID",
+		})
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	role, _ := c.Locals("role").(string)
+	request, err := utils.DeclineReschedule(uint(requestID), providerID, models.Actor{ID: &userID, Role: role}, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Reschedule request not found",
+			})
+		case errors.Is(err, utils.ErrRescheduleRequestClosed):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to decline reschedule request: " + err.Error(),
+		})
+	}
+
+	return c.JSON(request)
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/utils"
+	"gorm.io/gorm"
+)
+
+// validateServiceResources checks the quantities of a service's resource needs and that
+// every resource belongs to the provider and has enough units
+func validateServiceResources(providerID uint, needs []models.ServiceResource) error {
+	seen := map[uint]bool{}
+	for i, need := range needs {
+		if need.Quantity == 0 {
+			needs[i].Quantity = 1
+		}
+		if needs[i].Quantity < 0 {
+			return fmt.Errorf("quantity must be at least 1 at index %d", i)
+		}
+		if seen[need.ResourceID] {
+			return fmt.Errorf("resource %d is listed more than once", need.ResourceID)
+		}
+		seen[need.ResourceID] = true
+
+		var resource models.Resource
+		if err := db.DB.Where("id = ? AND provider_id = ?", need.ResourceID, providerID).First(&resource).Error; err != nil {
+			return fmt.Errorf("resource %d not found", need.ResourceID)
+		}
+		if needs[i].Quantity > resource.Quantity {
+			return fmt.Errorf("service needs %d of %s but only %d exist", needs[i].Quantity, resource.Name, resource.Quantity)
+		}
+	}
+	return nil
+}
+
+// GetResources lists the provider's rooms, chairs and equipment
+func GetResources(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var resources []models.Resource
+	if err := db.DB.Where("provider_id = ?", providerID).Order("name asc").Find(&resources).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch resources: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"resources": resources,
+	})
+}
+
+// CreateResource adds a resource with the number of units the provider has
+func CreateResource(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var resource models.Resource
+	if err := c.BodyParser(&resource); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if resource.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+	if resource.Quantity < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Quantity must be at least 1",
+		})
+	}
+	if resource.Quantity == 0 {
+		resource.Quantity = 1
+	}
+
+	resource.ID = 0
+	resource.ProviderID = providerID
+	if err := db.DB.Create(&resource).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create resource: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(resource)
+}
+
+// UpdateResource renames a resource or changes how many units exist
+func UpdateResource(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var resource models.Resource
+	if err := db.DB.Where("id = ? AND provider_id = ?", c.Params("id"), providerID).First(&resource).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Resource not found",
+		})
+	}
+
+	var input models.Resource
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if input.Quantity < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Quantity must be at least 1",
+		})
+	}
+
+	// Services may not need more units than the resource has
+	if input.Quantity > 0 {
+		var needed int
+		if err := db.DB.Model(&models.ServiceResource{}).Where("resource_id = ?", resource.ID).
+			Select("COALESCE(MAX(quantity), 0)").Scan(&needed).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to check services using the resource: " + err.Error(),
+			})
+		}
+		if input.Quantity < needed {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("A service needs %d units of this resource", needed),
+			})
+		}
+	}
+
+	if err := db.DB.Model(&resource).Updates(models.Resource{
+		Name:        input.Name,
+		Description: input.Description,
+		Quantity:    input.Quantity,
+	}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update resource: " + err.Error(),
+		})
+	}
+
+	return c.JSON(resource)
+}
+
+// DeleteResource removes a resource and drops it from the services that needed it
+func DeleteResource(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var resource models.Resource
+	if err := db.DB.Where("id = ? AND provider_id = ?", c.Params("id"), providerID).First(&resource).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Resource not found",
+		})
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("resource_id = ?", resource.ID).Delete(&models.ServiceResource{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&resource).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete resource: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Resource deleted successfully",
+	})
+}
+
+// UpdateServiceResources replaces the resources a service needs
+func UpdateServiceResources(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var service models.Service
+	if err := db.DB.Where("id = ? AND provider_id = ?", c.Params("id"), providerID).First(&service).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Service not found",
+		})
+	}
+
+	var needs []models.ServiceResource
+	if err := c.BodyParser(&needs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: expected an array of resources, " + err.Error(),
+		})
+	}
+	if err := validateServiceResources(providerID, needs); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("service_id = ?", service.ID).Delete(&models.ServiceResource{}).Error; err != nil {
+			return err
+		}
+		for _, need := range needs {
+			row := models.ServiceResource{ServiceID: service.ID, ResourceID: need.ResourceID, Quantity: need.Quantity}
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update service resources: " + err.Error(),
+		})
+	}
+
+	db.DB.Preload("Resources.Resource").First(&service, service.ID)
+	return c.JSON(service)
+}
+
+// GetResourceUtilisation reports how busy each resource is between two dates: bookings,
+// peak units in use, and booked unit minutes against the units times the opening hours
+func GetResourceUtilisation(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	// Dates are calendar days in the provider's time zone, defaulting to the coming week
+	loc := utils.ProviderLocation(providerID)
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 6)
+	if v := c.Query("from"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid from date, use YYYY-MM-DD",
+			})
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, loc); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid to date, use YYYY-MM-DD",
+			})
+		}
+	}
+	if to.Before(from) || to.Sub(from) > 92*24*time.Hour {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "to must be on or after from and at most 92 days later",
+		})
+	}
+	end := to.AddDate(0, 0, 1)
+
+	var resources []models.Resource
+	if err := db.DB.Where("provider_id = ?", providerID).Order("name asc").Find(&resources).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch resources: " + err.Error(),
+		})
+	}
+
+	needs, err := utils.LoadResourceNeeds(db.DB, providerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var appointments []models.Appointment
+	if err := db.DB.Preload("Service").
+		Where("provider_id = ? AND status IN ? AND start_time < ? AND end_time > ?", providerID,
+			append([]models.AppointmentStatus{models.StatusCompleted}, models.ActiveStatuses...), end, from).
+		Find(&appointments).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch appointments: " + err.Error(),
+		})
+	}
+	claims := utils.AppointmentClaims(appointments)
+
+	// Resources are available while the business is open
+	openMinutes := 0.0
+	for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+		schedule, err := utils.GetDaySchedule(providerID, nil, day)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if schedule.Closed {
+			continue
+		}
+		for _, shift := range schedule.Shifts {
+			openMinutes += shift.End.Sub(shift.Start).Minutes()
+		}
+		for _, brk := range schedule.Breaks {
+			openMinutes -= brk.End.Sub(brk.Start).Minutes()
+		}
+	}
+
+	type ResourceUtilisation struct {
+		ResourceID         uint    `json:"resource_id"`
+		Name               string  `json:"name"`
+		Quantity           int     `json:"quantity"`
+		Bookings           int     `json:"bookings"`
+		PeakInUse          int     `json:"peak_in_use"`
+		BookedUnitMinutes  float64 `json:"booked_unit_minutes"`
+		OpenUnitMinutes    float64 `json:"open_unit_minutes"`
+		UtilisationPercent float64 `json:"utilisation_percent"`
+	}
+	report := make([]ResourceUtilisation, 0, len(resources))
+	for _, resource := range resources {
+		bookings, booked := needs.Usage(resource.ID, claims, from, end)
+		open := openMinutes * float64(resource.Quantity)
+		percent := 0.0
+		if open > 0 {
+			percent = math.Round(booked/open*10000) / 100
+		}
+		report = append(report, ResourceUtilisation{
+			ResourceID:         resource.ID,
+			Name:               resource.Name,
+			Quantity:           resource.Quantity,
+			Bookings:           bookings,
+			PeakInUse:          needs.PeakUsage(resource.ID, claims, from, end),
+			BookedUnitMinutes:  booked,
+			OpenUnitMinutes:    open,
+			UtilisationPercent: percent,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"time_zone":   loc.String(),
+		"utilisation": report,
+	})
+}
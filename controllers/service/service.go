@@ -39,7 +39,7 @@ func GetService(c *fiber.Ctx) error {
 		})
 	}
 	var service models.Service
-	if err := db.DB.Preload("Provider.Role").First(&service, id).Error; err != nil {
+	if err := db.DB.Preload("Provider.Role").Preload("Resources.Resource").Preload("Options").First(&service, id).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Service not found",
 		})
@@ -170,6 +170,41 @@ func CreateService(c *fiber.Ctx) error {
 		})
 	}
 
+	// One-to-one services have a single seat per session
+	if service.Capacity < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Capacity must be at least 1",
+		})
+	}
+	if service.Capacity == 0 {
+		service.Capacity = 1
+	}
+	if service.MaxDailyBookings < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "max_daily_bookings cannot be negative",
+		})
+	}
+
+	// Rooms and equipment the service needs are created along with it
+	for i := range service.Resources {
+		service.Resources[i].ID = 0
+	}
+	if err := validateServiceResources(userID, service.Resources); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// So are its variants and add-ons
+	for i := range service.Options {
+		service.Options[i].ID = 0
+		if msg := validateServiceOption(&service.Options[i]); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": msg,
+			})
+		}
+	}
+
 	// Set ProviderID and Provider from JWT userID
 	service.ProviderID = userID
 	service.Provider = provider
@@ -225,8 +260,8 @@ func UpdateService(c *fiber.Ctx) error {
 		})
 	}
 
-	// Remove restricted fields
-	fieldsToIgnore := []string{"id", "ID", "provider", "Provider", "ProviderID", "provider_id"}
+	// Remove restricted fields; resources and options are changed through their own endpoints
+	fieldsToIgnore := []string{"id", "ID", "provider", "Provider", "ProviderID", "provider_id", "resources", "options"}
 	for _, field := range fieldsToIgnore {
 		delete(updateData, field)
 	}
@@ -269,6 +304,40 @@ func UpdateService(c *fiber.Ctx) error {
 				return nil
 			}
 		},
+		"capacity": func(v interface{}) interface{} {
+			switch val := v.(type) {
+			case float64:
+				if val < 1 || val != float64(int(val)) {
+					return nil
+				}
+				return int(val)
+			case string:
+				capacity, err := strconv.Atoi(val)
+				if err != nil || capacity < 1 {
+					return nil
+				}
+				return capacity
+			default:
+				return nil
+			}
+		},
+		"max_daily_bookings": func(v interface{}) interface{} {
+			switch val := v.(type) {
+			case float64:
+				if val < 0 || val != float64(int(val)) {
+					return nil
+				}
+				return int(val)
+			case string:
+				limit, err := strconv.Atoi(val)
+				if err != nil || limit < 0 {
+					return nil
+				}
+				return limit
+			default:
+				return nil
+			}
+		},
 		"cost": func(v interface{}) interface{} {
 			switch val := v.(type) {
 			case float64:
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// staffInput is the body accepted when creating or updating a staff member
+type staffInput struct {
+	Name       string `json:"name"`
+	Email      string `json:"email"`
+	Phone      string `json:"phone"`
+	Active     *bool  `json:"active"`
+	ServiceIDs []uint `json:"service_ids"` // Services the staff member performs
+}
+
+// findStaffMember loads one of the provider's staff members with their services
+func findStaffMember(providerID uint, staffID interface{}) (*models.StaffMember, error) {
+	var staff models.StaffMember
+	if err := db.DB.Preload("Services").Where("id = ? AND provider_id = ?", staffID, providerID).First(&staff).Error; err != nil {
+		return nil, err
+	}
+	return &staff, nil
+}
+
+// providerServices loads the services by ID, all of which must belong to the provider
+func providerServices(providerID uint, serviceIDs []uint) ([]models.Service, error) {
+	services := []models.Service{}
+	if len(serviceIDs) == 0 {
+		return services, nil
+	}
+	if err := db.DB.Where("id IN ? AND provider_id = ?", serviceIDs, providerID).Find(&services).Error; err != nil {
+		return nil, err
+	}
+	if len(services) != len(serviceIDs) {
+		return nil, fmt.Errorf("service_ids must be services of this provider")
+	}
+	return services, nil
+}
+
+// managedProviderID resolves the provider whose business the user manages
+func managedProviderID(c *fiber.Ctx) (uint, error) {
+	userID, ok := c.Locals("userID").(uint)
+	if !ok {
+		return 0, fmt.Errorf("user ID not found in context")
+	}
+	role, _ := c.Locals("role").(string)
+	return providerIDFor(userID, role)
+}
+
+// GetStaff lists the provider's staff members with the services they perform
+func GetStaff(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var staff []models.StaffMember
+	if err := db.DB.Preload("Services").Where("provider_id = ?", providerID).Order("name asc").Find(&staff).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch staff: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"staff": staff,
+	})
+}
+
+// CreateStaff adds a practitioner to the provider's business
+func CreateStaff(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	var input staffInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+	if input.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Name is required",
+		})
+	}
+
+	services, err := providerServices(providerID, input.ServiceIDs)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	staff := models.StaffMember{
+		ProviderID: providerID,
+		Name:       input.Name,
+		Email:      input.Email,
+		Phone:      input.Phone,
+		Active:     input.Active == nil || *input.Active,
+		Services:   services,
+	}
+	// Active defaults to true in the database, so an inactive member is saved explicitly
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&staff).Error; err != nil {
+			return err
+		}
+		if !staff.Active {
+			return tx.Model(&staff).Update("active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create staff member: " + err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(staff)
+}
+
+// UpdateStaff changes a staff member's details, active flag or services
+func UpdateStaff(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	staff, err := findStaffMember(providerID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Staff member not found",
+		})
+	}
+
+	var input staffInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: " + err.Error(),
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != "" {
+		updates["name"] = input.Name
+	}
+	if input.Email != "" {
+		updates["email"] = input.Email
+	}
+	if input.Phone != "" {
+		updates["phone"] = input.Phone
+	}
+	if input.Active != nil {
+		updates["active"] = *input.Active
+	}
+
+	var services []models.Service
+	if input.ServiceIDs != nil {
+		services, err = providerServices(providerID, input.ServiceIDs)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(staff).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if input.ServiceIDs != nil {
+			if err := tx.Model(staff).Association("Services").Replace(services); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update staff member: " + err.Error(),
+		})
+	}
+
+	staff, _ = findStaffMember(providerID, staff.ID)
+	return c.JSON(staff)
+}
+
+// DeleteStaff removes a staff member who has no upcoming appointments
+func DeleteStaff(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	staff, err := findStaffMember(providerID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Staff member not found",
+		})
+	}
+
+	var upcoming int64
+	if err := db.DB.Model(&models.Appointment{}).
+		Where("staff_id = ? AND start_time > ? AND status IN ?", staff.ID, time.Now(),
+			models.ActiveStatuses).
+		Count(&upcoming).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check upcoming appointments: " + err.Error(),
+		})
+	}
+	if upcoming > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Staff member has %d upcoming appointments; reassign or cancel them, or deactivate the staff member instead", upcoming),
+		})
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(staff).Association("Services").Clear(); err != nil {
+			return err
+		}
+		if err := replaceWorkingHours(tx, providerID, &staff.ID, nil); err != nil {
+			return err
+		}
+		return tx.Delete(staff).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete staff member: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Staff member deleted successfully",
+	})
+}
+
+// GetStaffWorkingHours returns a staff member's own weekly hours. An empty list means the
+// staff member works the business hours.
+func GetStaffWorkingHours(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	staff, err := findStaffMember(providerID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Staff member not found",
+		})
+	}
+
+	var workingHours []models.WorkingHours
+	if err := db.DB.Preload("Breaks").Where("provider_id = ? AND staff_id = ?", providerID, staff.ID).
+		Order("day_of_week, start_time").Find(&workingHours).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve working hours",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"working_hours":       workingHours,
+		"uses_business_hours": len(workingHours) == 0,
+	})
+}
+
+// UpdateStaffWorkingHours replaces a staff member's weekly hours; an empty list makes them
+// work the business hours again
+func UpdateStaffWorkingHours(c *fiber.Ctx) error {
+	providerID, err := managedProviderID(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Provider not found",
+		})
+	}
+
+	staff, err := findStaffMember(providerID, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Staff member not found",
+		})
+	}
+
+	var inputHours []models.WorkingHours
+	if err := c.BodyParser(&inputHours); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid input: expected an array of working hours, " + err.Error(),
+		})
+	}
+	if err := validateWorkingHours(inputHours); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		return replaceWorkingHours(tx, providerID, &staff.ID, inputHours)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update working hours: " + err.Error(),
+		})
+	}
+
+	var workingHours []models.WorkingHours
+	if err := db.DB.Preload("Breaks").Where("provider_id = ? AND staff_id = ?", providerID, staff.ID).
+		Order("day_of_week, start_time").Find(&workingHours).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to retrieve updated working hours: " + err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":             "Working hours updated successfully",
+		"working_hours":       workingHours,
+		"uses_business_hours": len(workingHours) == 0,
+	})
+}
@@ -18,8 +18,126 @@ func StartCronJobs() {
 	if err != nil {
 		log.Fatalf("Failed to add cron job: %v", err)
 	}
+	_, err = c.AddFunc("0 * * * *", materializeRecurringAppointments)
+	if err != nil {
+		log.Fatalf("Failed to add cron job: %v", err)
+	}
+	_, err = c.AddFunc("* * * * *", expireWaitlistOffers)
+	if err != nil {
+		log.Fatalf("Failed to add cron job: %v", err)
+	}
+	_, err = c.AddFunc("*/5 * * * *", markNoShows)
+	if err != nil {
+		log.Fatalf("Failed to add cron job: %v", err)
+	}
+	_, err = c.AddFunc("*/5 * * * *", expirePendingAppointments)
+	if err != nil {
+		log.Fatalf("Failed to add cron job: %v", err)
+	}
+	_, err = c.AddFunc("*/5 * * * *", expireRescheduleRequests)
+	if err != nil {
+		log.Fatalf("Failed to add cron job: %v", err)
+	}
 	c.Start()
-	log.Println("Cron job scheduler started for appointment reminders")
+	log.Println("Cron job scheduler started for appointment reminders and recurring appointments")
+}
+
+// expireRescheduleRequests closes reschedule requests whose proposed times have all passed
+func expireRescheduleRequests() {
+	expired, err := utils.ExpireRescheduleRequests(time.Now())
+	if err != nil {
+		log.Printf("Error expiring reschedule requests: %v", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("Expired %d reschedule requests", expired)
+	}
+}
+
+// expirePendingAppointments releases pending bookings the provider did not confirm in time
+func expirePendingAppointments() {
+	expired, err := utils.ExpirePendingAppointments(time.Now())
+	if err != nil {
+		log.Printf("Error expiring pending appointments: %v", err)
+		return
+	}
+	if expired > 0 {
+		log.Printf("Expired %d pending appointments", expired)
+	}
+}
+
+// markNoShows marks confirmed appointments nobody checked in for as no-shows
+func markNoShows() {
+	marked, err := utils.MarkNoShows(time.Now())
+	if err != nil {
+		log.Printf("Error marking no-shows: %v", err)
+		return
+	}
+	if marked > 0 {
+		log.Printf("Marked %d appointments as no-shows", marked)
+	}
+}
+
+// expireWaitlistOffers passes waitlist offers that were not accepted in time to the next customer
+func expireWaitlistOffers() {
+	if err := utils.ExpireWaitlistOffers(); err != nil {
+		log.Printf("Error expiring waitlist offers: %v", err)
+	}
+}
+
+// materializeRecurringAppointments books recurring occurrences up to the rolling horizon
+func materializeRecurringAppointments() {
+	horizon := utils.RecurrenceHorizon(time.Now())
+
+	var recurrences []models.Recurrence
+	err := db.DB.Where("ended = ? AND next_run <= ?", false, horizon).Find(&recurrences).Error
+	if err != nil {
+		log.Printf("Error fetching recurrences: %v", err)
+		return
+	}
+
+	fmt.Printf("Found %d recurring series to materialize\n", len(recurrences))
+
+	for i := range recurrences {
+		results, err := utils.MaterializeRecurrence(&recurrences[i], horizon)
+		if err != nil {
+			log.Printf("Failed to materialize recurrence %d: %v", recurrences[i].ID, err)
+		}
+
+		var flagged []utils.OccurrenceResult
+		for _, result := range results {
+			if result.Status == utils.OccurrenceFlagged {
+				flagged = append(flagged, result)
+			}
+		}
+		if len(flagged) == 0 {
+			continue
+		}
+
+		if err := sendRecurrenceFlagEmails(&recurrences[i], flagged); err != nil {
+			log.Printf("Failed to send flagged occurrence emails for recurrence %d: %v", recurrences[i].ID, err)
+		}
+	}
+}
+
+// sendRecurrenceFlagEmails tells the customer and provider about occurrences that could not be booked
+func sendRecurrenceFlagEmails(recurrence *models.Recurrence, flagged []utils.OccurrenceResult) error {
+	var appointment models.Appointment
+	err := db.DB.Unscoped().Preload("Customer").Preload("Provider").
+		First(&appointment, recurrence.AppointmentID).Error
+	if err != nil {
+		return err
+	}
+
+	loc := utils.ProviderLocation(appointment.ProviderID)
+	verb := "flagged because they could not be booked"
+	subject := fmt.Sprintf("Action Needed: Recurring Appointment - %s", appointment.Title)
+	if err := utils.SendEmail(appointment.Customer.Email, subject,
+		utils.SeriesChangeEmail(appointment.Customer.Name, verb, flagged, loc)); err != nil {
+		return err
+	}
+	return utils.SendEmail(appointment.Provider.Email, subject,
+		utils.SeriesChangeEmail(appointment.Provider.Name, verb, flagged, loc))
 }
 
 // sendAppointmentReminders checks for appointments and sends reminders
@@ -52,8 +170,9 @@ func sendAppointmentReminders() {
 	}
 }
 
-// sendReminderEmail constructs and sends the reminder email
+// sendReminderEmail constructs and sends the reminder email, with times in the provider's time zone
 func sendReminderEmail(appointment *models.Appointment) error {
+	loc := utils.ProviderLocation(appointment.ProviderID)
 	subject := fmt.Sprintf("Reminder: Upcoming Appointment - %s", appointment.Title)
 	body := fmt.Sprintf(`
 		<p>Dear %s,</p>
@@ -70,8 +189,8 @@ func sendReminderEmail(appointment *models.Appointment) error {
 		<p>Best regards,</p>
 		<p>Your Appointment Team</p>
 	`, appointment.Customer.Name, appointment.Service.Name, appointment.Provider.Name,
-		appointment.StartTime.Format("2006-01-02 15:04:05"),
-		appointment.EndTime.Format("2006-01-02 15:04:05"),
+		utils.FormatInZone(appointment.StartTime, loc),
+		utils.FormatInZone(appointment.EndTime, loc),
 		appointment.Status)
 
 	return utils.SendEmail(appointment.Customer.Email, subject, body)
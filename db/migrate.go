@@ -23,6 +23,24 @@ func Migrate() {
 		// &models.ReceptionistSettings{},
 		// &models.ProviderSettings{},
 		// &models.Review{},
+		&models.RecurrenceFlag{},
+		&models.AvailabilityOverride{},
+		&models.AvailabilityBreak{},
+		&models.WorkingBreak{},
+		&models.WaitlistEntry{},
+		&models.WaitlistOffer{},
+		&models.WaitlistOption{},
+		&models.StaffMember{},
+		&models.Resource{},
+		&models.ServiceResource{},
+		&models.Visit{},
+		&models.ServiceOption{},
+		&models.AppointmentOption{},
+		&models.CancellationPolicy{},
+		&models.WalkIn{},
+		&models.RescheduleRequest{},
+		&models.RescheduleOption{},
+		&models.AppointmentEvent{},
 	)
 	if err != nil {
 		log.Fatal("Failed to run migrations: ", err)
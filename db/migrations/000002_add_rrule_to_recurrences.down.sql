@@ -0,0 +1,2 @@
+ALTER TABLE recurrences DROP COLUMN IF EXISTS dtstart;
+ALTER TABLE recurrences DROP COLUMN IF EXISTS rrule;
@@ -0,0 +1,2 @@
+ALTER TABLE recurrences ADD COLUMN IF NOT EXISTS rrule TEXT;
+ALTER TABLE recurrences ADD COLUMN IF NOT EXISTS dtstart TIMESTAMPTZ;
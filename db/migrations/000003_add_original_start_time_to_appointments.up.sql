@@ -0,0 +1 @@
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS original_start_time TIMESTAMPTZ;
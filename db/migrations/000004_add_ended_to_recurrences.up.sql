@@ -0,0 +1 @@
+ALTER TABLE recurrences ADD COLUMN IF NOT EXISTS ended BOOLEAN NOT NULL DEFAULT FALSE;
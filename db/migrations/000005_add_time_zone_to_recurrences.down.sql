@@ -0,0 +1 @@
+ALTER TABLE recurrences DROP COLUMN IF EXISTS time_zone;
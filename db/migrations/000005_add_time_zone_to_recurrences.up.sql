@@ -0,0 +1 @@
+ALTER TABLE recurrences ADD COLUMN IF NOT EXISTS time_zone TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_appointments_staff_id;
+ALTER TABLE appointments DROP COLUMN IF EXISTS staff_id;
+
+DROP INDEX IF EXISTS idx_working_hours_staff_id;
+ALTER TABLE working_hours DROP COLUMN IF EXISTS staff_id;
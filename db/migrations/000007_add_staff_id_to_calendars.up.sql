@@ -0,0 +1,5 @@
+ALTER TABLE working_hours ADD COLUMN IF NOT EXISTS staff_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_working_hours_staff_id ON working_hours (staff_id);
+
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS staff_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_appointments_staff_id ON appointments (staff_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_appointments_visit_id;
+ALTER TABLE appointments DROP COLUMN IF EXISTS visit_id;
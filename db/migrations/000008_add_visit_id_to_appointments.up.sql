@@ -0,0 +1,2 @@
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS visit_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_appointments_visit_id ON appointments (visit_id);
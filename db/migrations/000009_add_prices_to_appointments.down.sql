@@ -0,0 +1,6 @@
+ALTER TABLE appointments DROP COLUMN IF EXISTS total_amount;
+ALTER TABLE appointments DROP COLUMN IF EXISTS tax_amount;
+ALTER TABLE appointments DROP COLUMN IF EXISTS discount_amount;
+ALTER TABLE appointments DROP COLUMN IF EXISTS list_price;
+
+ALTER TABLE provider_settings DROP COLUMN IF EXISTS tax_rate;
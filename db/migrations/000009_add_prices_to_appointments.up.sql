@@ -0,0 +1,14 @@
+ALTER TABLE provider_settings ADD COLUMN IF NOT EXISTS tax_rate NUMERIC NOT NULL DEFAULT 0;
+
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS list_price NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS discount_amount NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS tax_amount NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS total_amount NUMERIC NOT NULL DEFAULT 0;
+
+-- Existing appointments get the current service price, the best record available
+UPDATE appointments
+SET list_price = services.cost,
+    discount_amount = ROUND((services.cost * services.discount / 100)::NUMERIC, 2),
+    total_amount = services.cost - ROUND((services.cost * services.discount / 100)::NUMERIC, 2)
+FROM services
+WHERE appointments.service_id = services.id AND appointments.list_price = 0;
@@ -0,0 +1,2 @@
+ALTER TABLE appointments DROP COLUMN IF EXISTS cancellation_fee;
+ALTER TABLE appointments DROP COLUMN IF EXISTS reschedule_count;
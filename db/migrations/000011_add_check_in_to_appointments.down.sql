@@ -0,0 +1,6 @@
+ALTER TABLE appointments DROP COLUMN IF EXISTS no_show_by;
+ALTER TABLE appointments DROP COLUMN IF EXISTS no_show_at;
+ALTER TABLE appointments DROP COLUMN IF EXISTS started_by;
+ALTER TABLE appointments DROP COLUMN IF EXISTS started_at;
+ALTER TABLE appointments DROP COLUMN IF EXISTS checked_in_by;
+ALTER TABLE appointments DROP COLUMN IF EXISTS checked_in_at;
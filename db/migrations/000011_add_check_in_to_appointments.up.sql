@@ -0,0 +1,6 @@
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS checked_in_at TIMESTAMPTZ;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS checked_in_by INTEGER;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS started_by INTEGER;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS no_show_at TIMESTAMPTZ;
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS no_show_by INTEGER;
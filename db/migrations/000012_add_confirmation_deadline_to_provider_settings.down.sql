@@ -0,0 +1 @@
+ALTER TABLE provider_settings DROP COLUMN IF EXISTS confirmation_deadline_hours;
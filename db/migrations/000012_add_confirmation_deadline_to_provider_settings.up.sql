@@ -0,0 +1 @@
+ALTER TABLE provider_settings ADD COLUMN IF NOT EXISTS confirmation_deadline_hours INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE services DROP COLUMN IF EXISTS max_daily_bookings;
+ALTER TABLE provider_settings DROP COLUMN IF EXISTS min_customer_gap_minutes;
+ALTER TABLE provider_settings DROP COLUMN IF EXISTS max_customer_bookings;
+ALTER TABLE provider_settings DROP COLUMN IF EXISTS max_daily_appointments;
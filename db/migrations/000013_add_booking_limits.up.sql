@@ -0,0 +1,4 @@
+ALTER TABLE provider_settings ADD COLUMN IF NOT EXISTS max_daily_appointments INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE provider_settings ADD COLUMN IF NOT EXISTS max_customer_bookings INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE provider_settings ADD COLUMN IF NOT EXISTS min_customer_gap_minutes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE services ADD COLUMN IF NOT EXISTS max_daily_bookings INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AppointmentEventType string
+
+const (
+	EventCreated       AppointmentEventType = "created"
+	EventStatusChanged AppointmentEventType = "status_changed"
+	EventRescheduled   AppointmentEventType = "rescheduled"
+	EventUpdated       AppointmentEventType = "updated" // Any other field, such as the service, staff member or fee
+	EventDeleted       AppointmentEventType = "deleted"
+)
+
+// ErrEventImmutable is returned when something tries to change or remove a recorded event
+var ErrEventImmutable = errors.New("appointment events cannot be changed")
+
+// Actor is who made a change to an appointment
+type Actor struct {
+	ID   *uint  // Nil for scheduled jobs
+	Role string // Role of the user, or "system" for scheduled jobs
+}
+
+// SystemActor makes the changes done by scheduled jobs
+var SystemActor = Actor{Role: "system"}
+
+// CustomerActor is the customer with the given ID
+func CustomerActor(id uint) Actor {
+	return Actor{ID: &id, Role: "client"}
+}
+
+// AppointmentEvent is one entry in an appointment's append-only history of changes
+type AppointmentEvent struct {
+	ID            uint                 `json:"id" gorm:"primaryKey"`
+	AppointmentID uint                 `json:"appointment_id" gorm:"index"`
+	Type          AppointmentEventType `json:"type"`
+	ActorID       *uint                `json:"actor_id,omitempty"`
+	ActorRole     string               `json:"actor_role"`
+	Field         string               `json:"field,omitempty"` // Field that changed, empty for creation and deletion
+	OldValue      string               `json:"old_value,omitempty"`
+	NewValue      string               `json:"new_value,omitempty"`
+	Reason        string               `json:"reason,omitempty"`
+	CreatedAt     time.Time            `json:"created_at"`
+}
+
+// BeforeUpdate keeps the history append-only
+func (e *AppointmentEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrEventImmutable
+}
+
+// BeforeDelete keeps the history append-only
+func (e *AppointmentEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrEventImmutable
+}
+
+// RecordEvent appends event, made by actor, to its appointment's history
+func RecordEvent(tx *gorm.DB, event AppointmentEvent, actor Actor) error {
+	event.ID = 0
+	event.ActorID = actor.ID
+	event.ActorRole = actor.Role
+	return tx.Create(&event).Error
+}
+
+// EventTime formats a time for an event's old or new value
+func EventTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
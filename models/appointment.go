@@ -2,6 +2,8 @@ package models
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,10 +13,158 @@ type AppointmentStatus string
 
 type Recurrence struct {
 	gorm.Model
-	AppointmentID uint      `json:"appointment_id"`
-	NextRun       time.Time `json:"next_run"`
-	Frequency     string    `json:"frequency"` // "daily", "weekly", "monthly"
-	EndAfter      uint      `json:"end_after"` // Number of occurrences
+	AppointmentID uint        `json:"appointment_id"`
+	NextRun       time.Time   `json:"next_run"`
+	Frequency     string      `json:"frequency"`                           // "daily", "weekly", "monthly"
+	EndAfter      uint        `json:"end_after"`                           // Number of occurrences
+	RRule         string      `json:"rrule" gorm:"column:rrule;type:text"` // RFC 5545 RRULE with optional EXDATE lines
+	DTStart       time.Time   `json:"dtstart" gorm:"column:dtstart"`       // Start of the first occurrence
+	Upcoming      []time.Time `json:"upcoming,omitempty" gorm:"-"`
+	Ended         bool        `json:"ended"`     // No occurrences left to materialize
+	TimeZone      string      `json:"time_zone"` // IANA zone the rule is evaluated in, keeps wall-clock time across DST
+}
+
+// Normalize fills RRule and DTStart for a new series starting at start.
+// Legacy frequency/end_after input is translated into the equivalent RRULE.
+func (r *Recurrence) Normalize(start time.Time) error {
+	r.DTStart = start
+	if r.RRule == "" {
+		if r.Frequency == "" {
+			return fmt.Errorf("rrule or frequency is required for recurring appointments")
+		}
+		r.RRule = "FREQ=" + strings.ToUpper(r.Frequency)
+		if r.EndAfter > 0 {
+			r.RRule += fmt.Sprintf(";COUNT=%d", r.EndAfter)
+		}
+	}
+	_, err := r.Rule()
+	return err
+}
+
+// Rule parses the series RRULE in the series time zone. Rows created before RRULE
+// support only carry a Frequency, which maps onto the same FREQ starting at NextRun.
+func (r *Recurrence) Rule() (*RRule, error) {
+	dtstart := r.DTStart
+	if dtstart.IsZero() {
+		dtstart = r.NextRun
+	}
+	if r.TimeZone != "" {
+		loc, err := time.LoadLocation(r.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", r.TimeZone, err)
+		}
+		dtstart = dtstart.In(loc)
+	}
+	if r.RRule != "" {
+		return ParseRRule(r.RRule, dtstart)
+	}
+	return ParseRRule("FREQ="+strings.ToUpper(r.Frequency), dtstart)
+}
+
+// upgradeLegacy converts a frequency-only series into an RRULE anchored at the
+// current occurrence. Legacy EndAfter counts the occurrences left including it.
+func (r *Recurrence) upgradeLegacy(current time.Time) {
+	if r.RRule != "" {
+		return
+	}
+	r.DTStart = current
+	r.RRule = "FREQ=" + strings.ToUpper(r.Frequency)
+	if r.EndAfter > 0 {
+		r.RRule += fmt.Sprintf(";COUNT=%d", r.EndAfter)
+	}
+}
+
+// DueOccurrences returns the occurrences from NextRun up to horizon that still need an
+// appointment. latest is the most recent materialized occurrence of the series.
+func (r *Recurrence) DueOccurrences(latest, horizon time.Time) (*RRule, []time.Time, error) {
+	r.upgradeLegacy(latest)
+	rule, err := r.Rule()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	from := r.NextRun
+	if !from.After(latest) {
+		from = latest.Add(time.Second)
+	}
+	return rule, rule.Between(from, horizon), nil
+}
+
+// EndBefore stops the series so that no occurrence at or after t is generated
+func (r *Recurrence) EndBefore(current, t time.Time) {
+	r.upgradeLegacy(current)
+	until := t.Add(-time.Second).UTC().Format("20060102T150405Z")
+	r.RRule = setRRuleParts(r.RRule, map[string]string{"UNTIL": until, "COUNT": ""})
+}
+
+// Shift moves every future occurrence of the series by shift
+func (r *Recurrence) Shift(current time.Time, shift WallShift) {
+	r.upgradeLegacy(current)
+	r.DTStart = shift.Apply(r.DTStart)
+	r.NextRun = shift.Apply(r.NextRun)
+}
+
+// WallShift moves times by whole calendar days and a change of local time of day in Loc,
+// so a moved occurrence keeps its wall-clock time when a DST change lies in between
+type WallShift struct {
+	Days  int
+	Clock time.Duration
+	Loc   *time.Location
+}
+
+// NewWallShift returns the shift that moves from onto to in loc
+func NewWallShift(from, to time.Time, loc *time.Location) WallShift {
+	from, to = from.In(loc), to.In(loc)
+	fy, fm, fd := from.Date()
+	ty, tm, td := to.Date()
+	days := time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC).Sub(time.Date(fy, fm, fd, 0, 0, 0, 0, time.UTC))
+	return WallShift{
+		Days:  int(days.Hours() / 24),
+		Clock: timeOfDay(to) - timeOfDay(from),
+		Loc:   loc,
+	}
+}
+
+// Apply moves t by the shift
+func (s WallShift) Apply(t time.Time) time.Time {
+	local := t.In(s.Loc)
+	y, m, d := local.Date()
+	return time.Date(y, m, d+s.Days, local.Hour(), local.Minute(), local.Second(),
+		local.Nanosecond()+int(s.Clock), s.Loc)
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+// Split ends the series before the occurrence at and returns a new series that
+// continues from newStart with the same rule and the remaining COUNT.
+func (r *Recurrence) Split(current, at, newStart time.Time) (Recurrence, error) {
+	r.upgradeLegacy(current)
+	rule, err := r.Rule()
+	if err != nil {
+		return Recurrence{}, err
+	}
+
+	next := Recurrence{
+		AppointmentID: r.AppointmentID,
+		NextRun:       newStart,
+		Frequency:     r.Frequency,
+		RRule:         r.RRule,
+		DTStart:       newStart,
+		TimeZone:      r.TimeZone,
+	}
+	if rule.Count > 0 {
+		remaining := rule.Count - rule.CountBefore(at)
+		if remaining < 1 {
+			remaining = 1
+		}
+		next.RRule = setRRuleParts(next.RRule, map[string]string{"COUNT": fmt.Sprintf("%d", remaining)})
+	}
+
+	r.EndBefore(current, at)
+	return next, nil
 }
 
 const (
@@ -22,8 +172,17 @@ const (
 	StatusConfirmed AppointmentStatus = "confirmed"
 	StatusCanceled  AppointmentStatus = "canceled"
 	StatusCompleted AppointmentStatus = "completed"
+	// Front desk states between arrival and completion
+	StatusCheckedIn  AppointmentStatus = "checked_in"
+	StatusInProgress AppointmentStatus = "in_progress"
+	StatusNoShow     AppointmentStatus = "no_show"
+	// StatusExpired is a pending booking the provider did not confirm in time
+	StatusExpired AppointmentStatus = "expired"
 )
 
+// ActiveStatuses are the statuses of bookings that still take up their slot
+var ActiveStatuses = []AppointmentStatus{StatusPending, StatusConfirmed, StatusCheckedIn, StatusInProgress}
+
 type Appointment struct {
 	gorm.Model
 	Title        string            `json:"title"`
@@ -38,104 +197,127 @@ type Appointment struct {
 	Service      Service           `json:"service" gorm:"foreignKey:ServiceID"`
 	ProviderID   uint              `json:"provider_id"`
 	Provider     User              `json:"provider" gorm:"foreignKey:ProviderID"`
+	StaffID      *uint             `json:"staff_id,omitempty"` // Practitioner booked within the provider's business
+	Staff        *StaffMember      `json:"staff,omitempty" gorm:"foreignKey:StaffID"`
 	CustomerID   uint              `json:"customer_id"`
 	Customer     User              `json:"customer" gorm:"foreignKey:CustomerID"`
+	// OriginalStartTime is the series slot of an occurrence that was moved on its own
+	OriginalStartTime *time.Time `json:"original_start_time,omitempty"`
+	// VisitID links the appointments of a multi-service visit
+	VisitID *uint `json:"visit_id,omitempty"`
+	// Options are the variant and add-ons chosen for the service
+	Options []AppointmentOption `json:"options,omitempty" gorm:"foreignKey:AppointmentID"`
+	// Price at booking time, so later service price changes do not rewrite history
+	ListPrice      float64 `json:"list_price"`      // Service or variant cost plus add-ons
+	DiscountAmount float64 `json:"discount_amount"` // Service discount taken off the list price
+	TaxAmount      float64 `json:"tax_amount"`      // Provider tax on the discounted price
+	TotalAmount    float64 `json:"total_amount"`    // What the customer pays
+	// RescheduleCount is how often the customer moved the booking
+	RescheduleCount int `json:"reschedule_count"`
+	// CancellationFee is charged when the customer cancels late
+	CancellationFee float64 `json:"cancellation_fee"`
+	// RescheduleRequests are the customer's proposals to move the booking, oldest first
+	RescheduleRequests []RescheduleRequest `json:"reschedule_requests,omitempty" gorm:"foreignKey:AppointmentID"`
+	// When the front desk moved the appointment through its states and who did it; a nil
+	// NoShowBy means the no-show job marked it
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+	CheckedInBy *uint      `json:"checked_in_by,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	StartedBy   *uint      `json:"started_by,omitempty"`
+	NoShowAt    *time.Time `json:"no_show_at,omitempty"`
+	NoShowBy    *uint      `json:"no_show_by,omitempty"`
+	// Warnings tell the customer about clashes with their other appointments when it was booked or moved
+	Warnings []string `json:"warnings,omitempty" gorm:"-"`
 }
 
-func (a *Appointment) BeforeCreate(tx *gorm.DB) error {
-	if a.Status == "" {
-		a.Status = StatusPending
+// OccurrenceStart returns the series slot the appointment fills, ignoring individual reschedules
+func (a *Appointment) OccurrenceStart() time.Time {
+	if a.OriginalStartTime != nil {
+		return *a.OriginalStartTime
 	}
-	return nil
+	return a.StartTime
 }
 
-func (a *Appointment) UpdateStatus(tx *gorm.DB, newStatus AppointmentStatus) error {
-	switch a.Status {
-	case StatusPending:
-		if newStatus != StatusConfirmed && newStatus != StatusCanceled {
-			return fmt.Errorf("invalid transition from pending to %s", newStatus)
-		}
-	case StatusConfirmed:
-		if newStatus != StatusCompleted && newStatus != StatusCanceled {
-			return fmt.Errorf("invalid transition from confirmed to %s", newStatus)
-		}
-	case StatusCompleted, StatusCanceled:
-		return fmt.Errorf("no transitions allowed from %s", a.Status)
-	}
-
-	// Update the status
-	a.Status = newStatus
-	if err := tx.Save(a).Error; err != nil {
-		return err
-	}
+// SetPrice records the price of the appointment from its list price, the discount
+// percentage and the tax percentage charged on the discounted price
+func (a *Appointment) SetPrice(listPrice, discount, taxRate float64) {
+	a.ListPrice = roundMoney(listPrice)
+	a.DiscountAmount = roundMoney(listPrice * discount / 100)
+	a.TaxAmount = roundMoney((a.ListPrice - a.DiscountAmount) * taxRate / 100)
+	a.TotalAmount = roundMoney(a.ListPrice - a.DiscountAmount + a.TaxAmount)
+}
 
-	// Handle Recurrence after completion
-	if newStatus == StatusCompleted && a.IsRecurring {
-		fmt.Println("Scheduling next recurrence...", a.RecurPattern)
+// roundMoney rounds an amount to cents
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
 
-		// Preload Recurrence Pattern before scheduling next occurrence
-		if err := tx.Preload("RecurPattern").First(&a, a.ID).Error; err != nil {
-			return fmt.Errorf("failed to load recurrence pattern: %v", err)
-		}
+// Duration returns how long the appointment lasts, including any add-ons
+func (a *Appointment) Duration() time.Duration {
+	return a.EndTime.Sub(a.StartTime)
+}
 
-		return a.ScheduleNextRecurrence(tx)
+func (a *Appointment) BeforeCreate(tx *gorm.DB) error {
+	if a.Status == "" {
+		a.Status = StatusPending
 	}
-
 	return nil
 }
 
-func (a *Appointment) ScheduleNextRecurrence(tx *gorm.DB) error {
-	var nextTime time.Time
-
-	// Check if recurrence exists
-	if a.RecurPattern.ID == 0 {
-		return fmt.Errorf("no recurrence pattern found for appointment")
-	}
-	fmt.Println("Recurrence pattern found:", a.RecurPattern)
-	// Determine next occurrence based on recurrence frequency
-	switch a.RecurPattern.Frequency {
-	case "daily":
-		nextTime = a.StartTime.AddDate(0, 0, 1) // Add 1 day
-	case "weekly":
-		nextTime = a.StartTime.AddDate(0, 0, 7) // Add 7 days
-	case "monthly":
-		nextTime = a.StartTime.AddDate(0, 1, 0) // Add 1 month
-	default:
-		return fmt.Errorf("invalid recurrence frequency: %s", a.RecurPattern.Frequency)
-	}
+// statusTransitions lists the statuses each status may move to
+var statusTransitions = map[AppointmentStatus][]AppointmentStatus{
+	StatusPending:    {StatusConfirmed, StatusCanceled, StatusExpired},
+	StatusConfirmed:  {StatusCheckedIn, StatusInProgress, StatusCompleted, StatusCanceled, StatusNoShow},
+	StatusCheckedIn:  {StatusInProgress, StatusCompleted, StatusCanceled},
+	StatusInProgress: {StatusCompleted},
+}
 
-	fmt.Println("Next occurrence time:", nextTime)
+// IsFinal reports whether the appointment can no longer change status
+func (a *Appointment) IsFinal() bool {
+	return len(statusTransitions[a.Status]) == 0
+}
 
-	// Decrement remaining occurrences if EndAfter > 0
-	if a.RecurPattern.EndAfter > 0 {
-		a.RecurPattern.EndAfter--
-		if a.RecurPattern.EndAfter == 0 {
-			return nil // Stop recurrence if occurrences are exhausted
+// UpdateStatus moves the appointment to newStatus and records the change in its history. The
+// actor's ID is also stamped with the time on check-ins, starts and no-shows.
+func (a *Appointment) UpdateStatus(tx *gorm.DB, newStatus AppointmentStatus, actor Actor, reason string) error {
+	allowed := false
+	for _, next := range statusTransitions[a.Status] {
+		if next == newStatus {
+			allowed = true
+			break
 		}
-		// Update the Recurrence with decremented EndAfter
-		if err := tx.Save(&a.RecurPattern).Error; err != nil {
-			return fmt.Errorf("failed to update recurrence: %v", err)
+	}
+	if !allowed {
+		if a.IsFinal() {
+			return fmt.Errorf("no transitions allowed from %s", a.Status)
 		}
+		return fmt.Errorf("invalid transition from %s to %s", a.Status, newStatus)
 	}
-	fmt.Println("Updated recurrence pattern:", a.RecurPattern)
-	// Create the next recurring appointment
-	nextAppointment := Appointment{
-		Title:        a.Title,
-		Description:  a.Description,
-		StartTime:    nextTime,
-		EndTime:      nextTime.Add(a.EndTime.Sub(a.StartTime)),
-		Status:       StatusPending,
-		IsRecurring:  true,
-		RecurrenceID: a.RecurPattern.ID, // ✅ Set recurrence ID correctly
-		ServiceID:    a.ServiceID,
-		ProviderID:   a.ProviderID,
-		CustomerID:   a.CustomerID,
-	}
-	fmt.Println("Next appointment to be created:", nextAppointment)
-	// Save the new appointment
-	if err := tx.Create(&nextAppointment).Error; err != nil {
-		return fmt.Errorf("failed to create next recurrence: %v", err)
+
+	now := time.Now()
+	switch newStatus {
+	case StatusCheckedIn:
+		a.CheckedInAt, a.CheckedInBy = &now, actor.ID
+	case StatusInProgress:
+		a.StartedAt, a.StartedBy = &now, actor.ID
+	case StatusNoShow:
+		a.NoShowAt, a.NoShowBy = &now, actor.ID
 	}
 
-	return nil
+	// Update the status; recurring series are materialized ahead of time by the cron job
+	oldStatus := a.Status
+	a.Status = newStatus
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(a).Error; err != nil {
+			return err
+		}
+		return RecordEvent(tx, AppointmentEvent{
+			AppointmentID: a.ID,
+			Type:          EventStatusChanged,
+			Field:         "status",
+			OldValue:      string(oldStatus),
+			NewValue:      string(newStatus),
+			Reason:        reason,
+		}, actor)
+	})
 }
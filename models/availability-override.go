@@ -0,0 +1,28 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// AvailabilityOverride adjusts a provider's weekly working hours for a range of dates,
+// e.g. a holiday, time off or a day with custom hours
+type AvailabilityOverride struct {
+	gorm.Model
+	ProviderID uint                `json:"provider_id" gorm:"index"`
+	StartDate  string              `json:"start_date" gorm:"index"` // Format "YYYY-MM-DD"
+	EndDate    string              `json:"end_date" gorm:"index"`   // Format "YYYY-MM-DD", inclusive
+	IsClosed   bool                `json:"is_closed"`               // Closed for the whole day
+	StartTime  *string             `json:"start_time"`              // Optional custom opening "HH:MM", replaces the weekly hours
+	EndTime    *string             `json:"end_time"`                // Optional custom closing "HH:MM"
+	Breaks     []AvailabilityBreak `json:"breaks" gorm:"foreignKey:OverrideID"`
+	Reason     string              `json:"reason"`
+	StaffID    *uint               `json:"staff_id,omitempty" gorm:"index"` // Limits the override to one staff member
+}
+
+// AvailabilityBreak is an extra break added on the dates of an override
+type AvailabilityBreak struct {
+	gorm.Model
+	OverrideID uint   `json:"override_id"`
+	StartTime  string `json:"start_time"` // Format "HH:MM" in 24h
+	EndTime    string `json:"end_time"`   // Format "HH:MM" in 24h
+}
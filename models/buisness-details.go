@@ -40,6 +40,50 @@ type ProviderSettings struct {
 	Currency             string    `json:"currency"`
 	TimeZone             string    `json:"time_zone"`
 	Language             string    `json:"language"`
+	TaxRate              float64   `json:"tax_rate"` // Percentage added to the discounted price of bookings
+	// ConfirmationDeadlineHours is how long pending bookings wait for confirmation, 0 for no limit
+	ConfirmationDeadlineHours int `json:"confirmation_deadline_hours"`
+	// Booking limits, 0 for no limit
+	MaxDailyAppointments  int `json:"max_daily_appointments"`   // Appointments per day across the business
+	MaxCustomerBookings   int `json:"max_customer_bookings"`    // Upcoming pending or confirmed bookings per customer
+	MinCustomerGapMinutes int `json:"min_customer_gap_minutes"` // Free time between two bookings of the same customer
+}
+
+// IsClosedDuring reports whether the span from start to end overlaps the provider's closure
+func (s *ProviderSettings) IsClosedDuring(start, end time.Time) bool {
+	if s.ClosingStartDate.IsZero() || s.ClosingEndDate.IsZero() {
+		return false
+	}
+	return start.Before(s.ClosingEndDate) && end.After(s.ClosingStartDate)
+}
+
+// BookingWindowEnd returns the latest start time customers may book, if the provider limits it
+func (s *ProviderSettings) BookingWindowEnd(now time.Time) (time.Time, bool) {
+	if s.AdvanceBookingDays <= 0 {
+		return time.Time{}, false
+	}
+	return now.AddDate(0, 0, s.AdvanceBookingDays), true
+}
+
+// ConfirmationDeadline returns when a pending booking created at createdAt expires if the
+// provider has not confirmed it. Bookings never stay pending past their start time.
+func (s *ProviderSettings) ConfirmationDeadline(createdAt, start time.Time) (time.Time, bool) {
+	if s.ConfirmationDeadlineHours <= 0 {
+		return time.Time{}, false
+	}
+	deadline := createdAt.Add(time.Duration(s.ConfirmationDeadlineHours) * time.Hour)
+	if start.Before(deadline) {
+		deadline = start
+	}
+	return deadline, true
+}
+
+// InitialStatus is the status new bookings with this provider start in
+func (s *ProviderSettings) InitialStatus() AppointmentStatus {
+	if s.AutoConfirmBookings {
+		return StatusConfirmed
+	}
+	return StatusPending
 }
 
 type ReceptionistSettings struct {
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// CancellationPolicy limits how customers may cancel, reschedule or delete their bookings
+// with a provider. Zero values switch a rule off.
+type CancellationPolicy struct {
+	gorm.Model
+	ProviderID           uint    `json:"provider_id" gorm:"uniqueIndex"`
+	MinNoticeHours       int     `json:"min_notice_hours"`        // Cancellations with less notice are late and charged a fee
+	LateCancelFeePercent float64 `json:"late_cancel_fee_percent"` // Share of the appointment total charged for a late cancellation
+	MaxReschedules       int     `json:"max_reschedules"`         // Times a booking may be rescheduled, 0 for no limit
+	NoCancelHours        int     `json:"no_cancel_hours"`         // Bookings cannot be changed at all this close to the start
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecurrenceFlag records a series occurrence that could not be booked when it was
+// materialized, e.g. because of a conflict or a non-working day
+type RecurrenceFlag struct {
+	gorm.Model
+	RecurrenceID   uint      `json:"recurrence_id"`
+	ProviderID     uint      `json:"provider_id"`
+	CustomerID     uint      `json:"customer_id"`
+	ServiceID      uint      `json:"service_id"`
+	Service        Service   `json:"service" gorm:"foreignKey:ServiceID"`
+	OccurrenceTime time.Time `json:"occurrence_time"`
+	Reason         string    `json:"reason"`
+}
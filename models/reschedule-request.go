@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RescheduleRequestStatus string
+
+const (
+	ReschedulePending   RescheduleRequestStatus = "pending"
+	RescheduleAccepted  RescheduleRequestStatus = "accepted"
+	RescheduleDeclined  RescheduleRequestStatus = "declined"
+	RescheduleWithdrawn RescheduleRequestStatus = "withdrawn"
+	// RescheduleExpired means the proposed times passed, or the booking changed, before the
+	// provider decided
+	RescheduleExpired RescheduleRequestStatus = "expired"
+)
+
+// RescheduleRequest is a customer's proposal to move an appointment to one of a few new times.
+// The appointment keeps its slot until the provider accepts one of the times or declines.
+type RescheduleRequest struct {
+	gorm.Model
+	AppointmentID uint                    `json:"appointment_id" gorm:"index"`
+	CustomerID    uint                    `json:"customer_id"`
+	ProviderID    uint                    `json:"provider_id" gorm:"index"`
+	Status        RescheduleRequestStatus `json:"status"`
+	Note          string                  `json:"note"`
+	// OriginalStartTime is the booking's start when the times were proposed
+	OriginalStartTime time.Time          `json:"original_start_time"`
+	Options           []RescheduleOption `json:"options" gorm:"foreignKey:RequestID"`
+	AcceptedOptionID  *uint              `json:"accepted_option_id,omitempty"`
+	DecidedBy         *uint              `json:"decided_by,omitempty"`
+	DecidedAt         *time.Time         `json:"decided_at,omitempty"`
+	Response          string             `json:"response"` // Provider's reason when declining
+}
+
+// RescheduleOption is one of the times proposed in a reschedule request
+type RescheduleOption struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	RequestID uint      `json:"request_id" gorm:"index"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
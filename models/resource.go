@@ -0,0 +1,24 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Resource is something a provider has a limited number of, e.g. treatment rooms, chairs
+// or machines. Bookings of services that need it are limited by Quantity.
+type Resource struct {
+	gorm.Model
+	ProviderID  uint   `json:"provider_id" gorm:"index"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity" gorm:"default:1"` // Units available at the same time
+}
+
+// ServiceResource is a resource a service needs for the whole appointment
+type ServiceResource struct {
+	gorm.Model
+	ServiceID  uint     `json:"service_id" gorm:"index"`
+	ResourceID uint     `json:"resource_id" gorm:"index"`
+	Resource   Resource `json:"resource" gorm:"foreignKey:ResourceID"`
+	Quantity   int      `json:"quantity" gorm:"default:1"` // Units used per appointment, or per session for group services
+}
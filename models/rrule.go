@@ -0,0 +1,527 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRRulePeriods bounds how many FREQ periods are scanned when expanding a rule
+const maxRRulePeriods = 10000
+
+// WeekdayNum is a BYDAY entry such as "TU" (N = 0), "2MO" or "-1FR"
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// RRule is a parsed RFC 5545 recurrence rule anchored at DTStart.
+// Supported parts are FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT,
+// UNTIL, BYDAY, BYMONTHDAY and BYMONTH, plus EXDATE lines. YEARLY rules expand
+// within BYMONTH, defaulting to the DTSTART month.
+type RRule struct {
+	DTStart    time.Time
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	ExDates    []time.Time
+	exDays     map[string]bool // EXDATE values given as dates exclude the whole day
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// ParseRRule parses an RRULE value, optionally prefixed with "RRULE:" and followed by
+// "EXDATE:" lines, e.g. "RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH\nEXDATE:20270105T100000Z".
+// DTSTART lines are ignored; the series always starts at dtstart.
+func ParseRRule(text string, dtstart time.Time) (*RRule, error) {
+	r := &RRule{DTStart: dtstart, Interval: 1, exDays: map[string]bool{}}
+
+	lines := strings.FieldsFunc(text, func(c rune) bool { return c == '\n' || c == '\r' })
+	hasRule := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value := "RRULE", line
+		if idx := strings.Index(line, ":"); idx >= 0 {
+			name, value = strings.ToUpper(line[:idx]), line[idx+1:]
+			// Drop property parameters such as EXDATE;TZID=Asia/Kolkata
+			if semi := strings.Index(name, ";"); semi >= 0 {
+				name = name[:semi]
+			}
+		}
+
+		switch name {
+		case "RRULE":
+			if hasRule {
+				return nil, fmt.Errorf("only one RRULE is supported")
+			}
+			if err := r.parseRule(value); err != nil {
+				return nil, err
+			}
+			hasRule = true
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, dateOnly, err := parseRRuleTime(strings.TrimSpace(v), dtstart.Location())
+				if err != nil {
+					return nil, fmt.Errorf("invalid EXDATE %q: %v", v, err)
+				}
+				if dateOnly {
+					r.exDays[t.Format("2006-01-02")] = true
+				}
+				r.ExDates = append(r.ExDates, t)
+			}
+		case "DTSTART":
+			// The first appointment is the series start
+		default:
+			return nil, fmt.Errorf("unsupported property %s", name)
+		}
+	}
+
+	if !hasRule {
+		return nil, fmt.Errorf("RRULE is required")
+	}
+	return r, nil
+}
+
+func (r *RRule) parseRule(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid RRULE part %q", part)
+		}
+		key, val := strings.ToUpper(kv[0]), kv[1]
+
+		switch key {
+		case "FREQ":
+			switch strings.ToUpper(val) {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.Freq = strings.ToUpper(val)
+			default:
+				return fmt.Errorf("unsupported FREQ %s", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid INTERVAL %s", val)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid COUNT %s", val)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, dateOnly, err := parseRRuleTime(val, r.DTStart.Location())
+			if err != nil {
+				return fmt.Errorf("invalid UNTIL %s: %v", val, err)
+			}
+			if dateOnly {
+				// A date-only UNTIL includes the whole day
+				t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				wd, err := parseWeekdayNum(d)
+				if err != nil {
+					return err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return fmt.Errorf("invalid BYMONTHDAY %s", d)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, m := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(m)
+				if err != nil || n < 1 || n > 12 {
+					return fmt.Errorf("invalid BYMONTH %s", m)
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			if strings.ToUpper(val) != "MO" {
+				return fmt.Errorf("only WKST=MO is supported")
+			}
+		default:
+			return fmt.Errorf("unsupported RRULE part %s", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	if r.Freq == "DAILY" || r.Freq == "WEEKLY" {
+		for _, wd := range r.ByDay {
+			if wd.N != 0 {
+				return fmt.Errorf("BYDAY ordinals are only valid with MONTHLY or YEARLY")
+			}
+		}
+	}
+	return nil
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %s", s)
+	}
+	day, ok := rruleWeekdays[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid BYDAY %s", s)
+	}
+	wd := WeekdayNum{Day: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return WeekdayNum{}, fmt.Errorf("invalid BYDAY %s", s)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+// parseRRuleTime parses DATE or DATE-TIME values; floating times use loc
+func parseRRuleTime(s string, loc *time.Location) (time.Time, bool, error) {
+	switch {
+	case len(s) == 8:
+		t, err := time.ParseInLocation("20060102", s, loc)
+		return t, true, err
+	case strings.HasSuffix(s, "Z"):
+		t, err := time.Parse("20060102T150405Z", s)
+		return t, false, err
+	default:
+		t, err := time.ParseInLocation("20060102T150405", s, loc)
+		return t, false, err
+	}
+}
+
+// Occurrences returns up to limit occurrences strictly after the given time
+func (r *RRule) Occurrences(after time.Time, limit int) []time.Time {
+	var result []time.Time
+	if limit <= 0 {
+		return result
+	}
+	r.iterate(func(t time.Time) bool {
+		if t.After(after) {
+			result = append(result, t)
+		}
+		return len(result) < limit
+	})
+	return result
+}
+
+// Between returns the occurrences in [from, to)
+func (r *RRule) Between(from, to time.Time) []time.Time {
+	var result []time.Time
+	r.iterate(func(t time.Time) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			result = append(result, t)
+		}
+		return true
+	})
+	return result
+}
+
+// After returns the first occurrence strictly after t
+func (r *RRule) After(t time.Time) (time.Time, bool) {
+	next := r.Occurrences(t, 1)
+	if len(next) == 0 {
+		return time.Time{}, false
+	}
+	return next[0], true
+}
+
+// iterate calls fn for every occurrence in order until fn returns false or the rule ends
+func (r *RRule) iterate(fn func(time.Time) bool) {
+	r.walk(func(t time.Time, excluded bool) bool {
+		return excluded || fn(t)
+	})
+}
+
+// CountBefore returns how many instances, including EXDATEs, fall strictly before t
+func (r *RRule) CountBefore(t time.Time) int {
+	count := 0
+	r.walk(func(o time.Time, _ bool) bool {
+		if !o.Before(t) {
+			return false
+		}
+		count++
+		return true
+	})
+	return count
+}
+
+// walk visits every instance of the rule, including those removed by EXDATE.
+// DTSTART is always the first instance; COUNT is applied before EXDATE as in RFC 5545.
+func (r *RRule) walk(fn func(t time.Time, excluded bool) bool) {
+	count := 0
+	emit := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		count++
+		if !fn(t, r.isExcluded(t)) {
+			return false
+		}
+		return r.Count == 0 || count < r.Count
+	}
+
+	if !emit(r.DTStart) {
+		return
+	}
+	for period := 0; period < maxRRulePeriods; period++ {
+		for _, t := range r.candidates(period) {
+			if !t.After(r.DTStart) {
+				continue
+			}
+			if !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+func (r *RRule) isExcluded(t time.Time) bool {
+	if r.exDays[t.Format("2006-01-02")] {
+		return true
+	}
+	for _, ex := range r.ExDates {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the sorted occurrences generated by the n-th FREQ period
+func (r *RRule) candidates(n int) []time.Time {
+	start := r.DTStart
+	loc := start.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, start.Hour(), start.Minute(), start.Second(), 0, loc)
+	}
+
+	var days []time.Time
+	switch r.Freq {
+	case "DAILY":
+		day := at(start.Year(), start.Month(), start.Day()+n*r.Interval)
+		if r.matchesDay(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		// Weeks start on Monday (WKST=MO)
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := at(start.Year(), start.Month(), start.Day()-offset+7*n*r.Interval)
+		weekdays := []time.Weekday{start.Weekday()}
+		if len(r.ByDay) > 0 {
+			weekdays = nil
+			for _, wd := range r.ByDay {
+				weekdays = append(weekdays, wd.Day)
+			}
+		}
+		for _, wd := range weekdays {
+			day := at(monday.Year(), monday.Month(), monday.Day()+(int(wd)+6)%7)
+			if r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		first := at(start.Year(), start.Month()+time.Month(n*r.Interval), 1)
+		if r.matchesMonth(first) {
+			days = r.monthDays(first, at)
+		}
+	case "YEARLY":
+		year := start.Year() + n*r.Interval
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{start.Month()}
+		}
+		for _, m := range months {
+			days = append(days, r.monthDays(at(year, m, 1), at)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return dedupeTimes(days)
+}
+
+// monthDays expands BYMONTHDAY and BYDAY within the month starting at first
+func (r *RRule) monthDays(first time.Time, at func(int, time.Month, int) time.Time) []time.Time {
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+
+	var byMonthDay map[int]bool
+	if len(r.ByMonthDay) > 0 {
+		byMonthDay = map[int]bool{}
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = daysInMonth + 1 + d
+			}
+			if d >= 1 && d <= daysInMonth {
+				byMonthDay[d] = true
+			}
+		}
+	}
+
+	var byDay map[int]bool
+	if len(r.ByDay) > 0 {
+		byDay = map[int]bool{}
+		for _, wd := range r.ByDay {
+			var matches []int
+			for d := 1; d <= daysInMonth; d++ {
+				if at(first.Year(), first.Month(), d).Weekday() == wd.Day {
+					matches = append(matches, d)
+				}
+			}
+			switch {
+			case wd.N == 0:
+				for _, d := range matches {
+					byDay[d] = true
+				}
+			case wd.N > 0 && wd.N <= len(matches):
+				byDay[matches[wd.N-1]] = true
+			case wd.N < 0 && -wd.N <= len(matches):
+				byDay[matches[len(matches)+wd.N]] = true
+			}
+		}
+	}
+
+	var days []time.Time
+	for d := 1; d <= daysInMonth; d++ {
+		switch {
+		case byMonthDay == nil && byDay == nil:
+			if d != r.DTStart.Day() {
+				continue
+			}
+		case byMonthDay != nil && !byMonthDay[d]:
+			continue
+		case byDay != nil && !byDay[d]:
+			continue
+		}
+		days = append(days, at(first.Year(), first.Month(), d))
+	}
+	return days
+}
+
+// matchesDay applies the BYxxx filters used by DAILY rules
+func (r *RRule) matchesDay(t time.Time) bool {
+	if !r.matchesMonth(t) {
+		return false
+	}
+	if len(r.ByDay) > 0 {
+		found := false
+		for _, wd := range r.ByDay {
+			if wd.Day == t.Weekday() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.ByMonthDay) > 0 {
+		daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+		found := false
+		for _, d := range r.ByMonthDay {
+			if d == t.Day() || daysInMonth+1+d == t.Day() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *RRule) matchesMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if m == t.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupeTimes(times []time.Time) []time.Time {
+	result := times[:0]
+	for i, t := range times {
+		if i == 0 || !t.Equal(times[i-1]) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// setRRuleParts rewrites the RRULE line of text, replacing the given parts and
+// dropping those mapped to an empty value. EXDATE lines are kept as they are.
+func setRRuleParts(text string, parts map[string]string) string {
+	lines := strings.FieldsFunc(text, func(c rune) bool { return c == '\n' || c == '\r' })
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		prefix, value := "", line
+		if idx := strings.Index(line, ":"); idx >= 0 {
+			if strings.ToUpper(line[:idx]) != "RRULE" {
+				continue
+			}
+			prefix, value = line[:idx+1], line[idx+1:]
+		}
+
+		var kept []string
+		for _, part := range strings.Split(value, ";") {
+			key := strings.ToUpper(strings.SplitN(part, "=", 2)[0])
+			if _, ok := parts[key]; ok || part == "" {
+				continue
+			}
+			kept = append(kept, part)
+		}
+		keys := make([]string, 0, len(parts))
+		for key := range parts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if parts[key] != "" {
+				kept = append(kept, key+"="+parts[key])
+			}
+		}
+		lines[i] = prefix + strings.Join(kept, ";")
+	}
+	return strings.Join(lines, "\n")
+}
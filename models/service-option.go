@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ServiceOptionKind string
+
+const (
+	// OptionVariant replaces the service's duration and cost, e.g. "long hair"
+	OptionVariant ServiceOptionKind = "variant"
+	// OptionAddOn is added on top of the service, e.g. "+ head massage"
+	OptionAddOn ServiceOptionKind = "add_on"
+)
+
+// ServiceOption is a variant or add-on a customer can choose when booking a service
+type ServiceOption struct {
+	gorm.Model
+	ServiceID uint              `json:"service_id" gorm:"index"`
+	Kind      ServiceOptionKind `json:"kind"`
+	Name      string            `json:"name"`
+	Duration  time.Duration     `json:"duration"` // Replaces the service duration for a variant, added to it for an add-on
+	Cost      float64           `json:"cost"`     // Replaces the service cost for a variant, added to it for an add-on
+}
+
+// AppointmentOption records a variant or add-on chosen for an appointment as it was at booking time
+type AppointmentOption struct {
+	gorm.Model
+	AppointmentID uint              `json:"appointment_id" gorm:"index"`
+	OptionID      uint              `json:"option_id"`
+	Kind          ServiceOptionKind `json:"kind"`
+	Name          string            `json:"name"`
+	Duration      time.Duration     `json:"duration"`
+	Cost          float64           `json:"cost"`
+}
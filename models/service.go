@@ -8,15 +8,27 @@ import (
 
 type Service struct {
 	gorm.Model
-	Name            string        `json:"name"`
-	Description     string        `json:"description"`
-	Duration        time.Duration `json:"duration"`
-	Cost            float64       `json:"cost"`
-	BufferTime      time.Duration `json:"buffer_time"` // Time between appointments
-	ProviderID      uint          `json:"provider_id"`
-	Provider        User          `json:"provider" gorm:"foreignKey:ProviderID"`
-	Discount        float64       `json:"discount"` // Discount percentage
-	DiscountedPrice float64       `json:"discounted_price" gorm:"-"`
+	Name             string            `json:"name"`
+	Description      string            `json:"description"`
+	Duration         time.Duration     `json:"duration"`
+	Cost             float64           `json:"cost"`
+	BufferTime       time.Duration     `json:"buffer_time"` // Time between appointments
+	ProviderID       uint              `json:"provider_id"`
+	Provider         User              `json:"provider" gorm:"foreignKey:ProviderID"`
+	Discount         float64           `json:"discount"` // Discount percentage
+	DiscountedPrice  float64           `json:"discounted_price" gorm:"-"`
+	Capacity         int               `json:"capacity" gorm:"default:1"` // Customers per session, above 1 for group classes
+	MaxDailyBookings int               `json:"max_daily_bookings"`        // Appointments per day for the service, 0 for no limit
+	Resources        []ServiceResource `json:"resources,omitempty" gorm:"foreignKey:ServiceID"`
+	Options          []ServiceOption   `json:"options,omitempty" gorm:"foreignKey:ServiceID"` // Variants and add-ons
+}
+
+// Seats returns how many customers can book the same session
+func (s *Service) Seats() int {
+	if s.Capacity < 1 {
+		return 1
+	}
+	return s.Capacity
 }
 
 func (s *Service) AfterFind(tx *gorm.DB) (err error) {
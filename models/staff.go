@@ -0,0 +1,29 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// StaffMember is a practitioner working under a provider's business. Staff have their
+// own working hours and appointments and only perform the services assigned to them.
+type StaffMember struct {
+	gorm.Model
+	ProviderID   uint           `json:"provider_id" gorm:"index"`
+	Provider     User           `json:"-" gorm:"foreignKey:ProviderID"`
+	Name         string         `json:"name"`
+	Email        string         `json:"email"`
+	Phone        string         `json:"phone"`
+	Active       bool           `json:"active" gorm:"default:true"`
+	Services     []Service      `json:"services" gorm:"many2many:staff_services;"`
+	WorkingHours []WorkingHours `json:"working_hours,omitempty" gorm:"foreignKey:StaffID"` // Empty when the staff member keeps the business hours
+}
+
+// Performs reports whether the staff member is assigned the service
+func (s *StaffMember) Performs(serviceID uint) bool {
+	for _, service := range s.Services {
+		if service.ID == serviceID {
+			return true
+		}
+	}
+	return false
+}
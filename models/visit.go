@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Visit groups the appointments of several services a customer books back to back
+type Visit struct {
+	gorm.Model
+	CustomerID   uint          `json:"customer_id" gorm:"index"`
+	ProviderID   uint          `json:"provider_id" gorm:"index"`
+	StartTime    time.Time     `json:"start_time"`
+	EndTime      time.Time     `json:"end_time"`
+	TotalPrice   float64       `json:"total_price"` // Sum of what the customer pays for the services
+	Appointments []Appointment `json:"appointments" gorm:"foreignKey:VisitID"`
+	Warnings     []string      `json:"warnings,omitempty" gorm:"-"` // Clashes with the customer's other appointments
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WaitlistStatus string
+
+const (
+	WaitlistWaiting  WaitlistStatus = "waiting"
+	WaitlistOffered  WaitlistStatus = "offered"
+	WaitlistBooked   WaitlistStatus = "booked"
+	WaitlistCanceled WaitlistStatus = "canceled"
+)
+
+// WaitlistEntry records a customer's interest in any opening for a service within a date range
+type WaitlistEntry struct {
+	gorm.Model
+	CustomerID    uint           `json:"customer_id"`
+	Customer      User           `json:"customer" gorm:"foreignKey:CustomerID"`
+	ProviderID    uint           `json:"provider_id"`
+	ServiceID     uint           `json:"service_id"`
+	Service       Service        `json:"service" gorm:"foreignKey:ServiceID"`
+	StaffID       *uint          `json:"staff_id,omitempty"` // Preferred staff member, nil for any available
+	FromDate      time.Time      `json:"from_date"`          // Earliest acceptable start time
+	ToDate        time.Time      `json:"to_date"`            // Latest acceptable start time
+	Status        WaitlistStatus `json:"status"`
+	AppointmentID *uint          `json:"appointment_id,omitempty"` // Set once an offer is accepted
+	// Options are the variant and add-ons the customer wants, which size the offered slot
+	Options []WaitlistOption `json:"options,omitempty" gorm:"foreignKey:EntryID"`
+}
+
+// WaitlistOption is a variant or add-on chosen for a waitlist entry
+type WaitlistOption struct {
+	gorm.Model
+	EntryID  uint              `json:"entry_id" gorm:"index"`
+	OptionID uint              `json:"option_id"`
+	Kind     ServiceOptionKind `json:"kind"`
+}
+
+type WaitlistOfferStatus string
+
+const (
+	OfferPending  WaitlistOfferStatus = "pending"
+	OfferAccepted WaitlistOfferStatus = "accepted"
+	OfferDeclined WaitlistOfferStatus = "declined"
+	OfferExpired  WaitlistOfferStatus = "expired"
+)
+
+// WaitlistOffer holds a freed slot for one waitlisted customer until it expires
+type WaitlistOffer struct {
+	gorm.Model
+	EntryID    uint                `json:"entry_id"`
+	CustomerID uint                `json:"customer_id"`
+	ProviderID uint                `json:"provider_id"`
+	ServiceID  uint                `json:"service_id"`
+	StaffID    *uint               `json:"staff_id,omitempty"`
+	StartTime  time.Time           `json:"start_time"`
+	EndTime    time.Time           `json:"end_time"`
+	ExpiresAt  time.Time           `json:"expires_at"`
+	Status     WaitlistOfferStatus `json:"status"`
+}
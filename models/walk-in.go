@@ -0,0 +1,70 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WalkInStatus string
+
+const (
+	WalkInWaiting WalkInStatus = "waiting"
+	WalkInServing WalkInStatus = "serving"
+	WalkInDone    WalkInStatus = "done"
+	WalkInLeft    WalkInStatus = "left" // Left before being served
+)
+
+// walkInTransitions lists the statuses each walk-in status may move to
+var walkInTransitions = map[WalkInStatus][]WalkInStatus{
+	WalkInWaiting: {WalkInServing, WalkInLeft},
+	WalkInServing: {WalkInDone},
+}
+
+// WalkIn is a customer who arrived without a booking and waits in the provider's queue for
+// a gap between booked appointments
+type WalkIn struct {
+	gorm.Model
+	ProviderID uint         `json:"provider_id" gorm:"index"`
+	ServiceID  uint         `json:"service_id"`
+	Service    Service      `json:"service" gorm:"foreignKey:ServiceID"`
+	StaffID    *uint        `json:"staff_id,omitempty"`    // Requested staff member, or who served them; nil for any available
+	CustomerID *uint        `json:"customer_id,omitempty"` // Registered customer, nil for a guest
+	Name       string       `json:"name"`
+	Phone      string       `json:"phone"`
+	Status     WalkInStatus `json:"status" gorm:"index"`
+	AddedBy    uint         `json:"added_by"`
+	ArrivedAt  time.Time    `json:"arrived_at"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// UpdateStatus moves the walk-in to newStatus and stamps when service started or ended
+func (w *WalkIn) UpdateStatus(tx *gorm.DB, newStatus WalkInStatus) error {
+	allowed := false
+	for _, next := range walkInTransitions[w.Status] {
+		if next == newStatus {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("invalid transition from %s to %s", w.Status, newStatus)
+	}
+
+	now := time.Now()
+	switch newStatus {
+	case WalkInServing:
+		w.StartedAt = &now
+	case WalkInDone, WalkInLeft:
+		w.FinishedAt = &now
+	}
+	w.Status = newStatus
+	return tx.Model(w).Updates(map[string]interface{}{
+		"status":      w.Status,
+		"staff_id":    w.StaffID,
+		"started_at":  w.StartedAt,
+		"finished_at": w.FinishedAt,
+	}).Error
+}
@@ -16,13 +16,25 @@ const (
 	Saturday
 )
 
+// WorkingHours is one working interval (shift) on a day of the week. A day may
+// have several shifts, each with any number of breaks.
 type WorkingHours struct {
 	gorm.Model
-	ProviderID uint      `json:"provider_id"`
-	Provider   User      `json:"provider" gorm:"foreignKey:ProviderID"`
-	DayOfWeek  DayOfWeek `json:"day_of_week"`
-	StartTime  string    `json:"start_time"`  // Format "HH:MM" in 24h
-	EndTime    string    `json:"end_time"`    // Format "HH:MM" in 24h
-	BreakStart *string   `json:"break_start"` // Optional break start time
-	BreakEnd   *string   `json:"break_end"`   // Optional break end time
+	ProviderID uint           `json:"provider_id"`
+	Provider   User           `json:"provider" gorm:"foreignKey:ProviderID"`
+	StaffID    *uint          `json:"staff_id,omitempty"` // Set for a staff member's own hours, nil for the business hours
+	DayOfWeek  DayOfWeek      `json:"day_of_week"`
+	StartTime  string         `json:"start_time"`  // Format "HH:MM" in 24h
+	EndTime    string         `json:"end_time"`    // Format "HH:MM" in 24h
+	BreakStart *string        `json:"break_start"` // Optional break start time
+	BreakEnd   *string        `json:"break_end"`   // Optional break end time
+	Breaks     []WorkingBreak `json:"breaks" gorm:"foreignKey:WorkingHoursID"`
+}
+
+// WorkingBreak is a break inside a working interval
+type WorkingBreak struct {
+	gorm.Model
+	WorkingHoursID uint   `json:"working_hours_id"`
+	StartTime      string `json:"start_time"` // Format "HH:MM" in 24h
+	EndTime        string `json:"end_time"`   // Format "HH:MM" in 24h
 }
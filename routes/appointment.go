@@ -10,11 +10,21 @@ import (
 func SetupAppointmentRoutes(app *fiber.App) {
 	appointment := app.Group("/appointments", middleware.Protected())
 	appointment.Get("/", consumer.GetAllAppointments)
+	appointment.Get("/flagged", consumer.GetFlaggedOccurrences)
+	appointment.Post("/holds", consumer.HoldSlot)
+	appointment.Delete("/holds/:provider_id", consumer.ReleaseSlot)
+	appointment.Post("/visits", middleware.RequirePermission("appointments", "create"), consumer.CreateVisit)
+	appointment.Get("/visits/:id", consumer.GetVisit)
 	appointment.Get("/:id", consumer.GetAppointment)
+	appointment.Get("/:id/history", consumer.GetAppointmentHistory)
 	appointment.Get("/service/:id", consumer.GetServiceDetails)
 	appointment.Post("/", middleware.Protected(), middleware.RequirePermission("appointments", "create"), consumer.CreateAppointment)
 	appointment.Patch("/:id", middleware.Protected(), middleware.RequirePermission("appointments", "update"), consumer.UpdateAppointment)
 	appointment.Delete("/:id", middleware.Protected(), middleware.RequirePermission("appointments", "delete"), consumer.DeleteAppointment)
+	appointment.Patch("/:id/series", middleware.RequirePermission("appointments", "update"), consumer.UpdateAppointmentSeries)
+	appointment.Get("/:id/reschedule-requests", consumer.GetRescheduleRequests)
+	appointment.Post("/:id/reschedule-requests", middleware.RequirePermission("appointments", "update"), consumer.ProposeReschedule)
+	appointment.Delete("/:id/reschedule-requests/:request_id", middleware.RequirePermission("appointments", "update"), consumer.WithdrawRescheduleRequest)
 
 	//_______________________________________________________________________________
 	//Provider appointments
@@ -23,12 +33,26 @@ func SetupAppointmentRoutes(app *fiber.App) {
 	providers.Get("/", consumer.GetAllProviders)
 	providers.Get("/:id", consumer.GetProviderDetails)
 	providers.Get("/:id/services", consumer.GetProviderServices)
+	providers.Get("/:id/staff", consumer.GetProviderStaff)
+	providers.Get("/:id/cancellation-policy", consumer.GetProviderCancellationPolicy)
 	providers.Get("/search/service", consumer.SearchProviders)
 	providers.Get("/category/:categoryId", consumer.GetProvidersByCategory)
 	providers.Get("/featured", consumer.GetFeaturedProviders)
 	providers.Get("/nearby", consumer.GetNearbyProviders)
 	providers.Get("/available-time-slots/:provider_id", consumer.GetAvailableSlots)
 
+	//Waitlist______________________________________________________________
+	waitlist := app.Group("/waitlist", middleware.Protected())
+
+	waitlist.Get("/", consumer.GetMyWaitlist)
+	waitlist.Post("/", consumer.JoinWaitlist)
+	waitlist.Delete("/:id", consumer.LeaveWaitlist)
+	waitlist.Post("/offers/:id/accept", consumer.AcceptWaitlistOffer)
+	waitlist.Post("/offers/:id/decline", consumer.DeclineWaitlistOffer)
+
+	//Walk-ins_______________________________________________________________
+	app.Get("/walk-ins", middleware.Protected(), consumer.GetMyWalkIns)
+
 	//Reviews________________________________________________________________
 	reviewRoutes := app.Group("/reviews", middleware.Protected())
 
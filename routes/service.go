@@ -16,6 +16,13 @@ func SetupServiceRoutes(app *fiber.App) {
 	service.Post("/", middleware.Protected(), middleware.RequirePermission("services", "create"), services.CreateService)
 	service.Patch("/:id", middleware.Protected(), middleware.RequirePermission("services", "update"), services.UpdateService)
 	service.Delete("/:id", middleware.Protected(), middleware.RequirePermission("services", "delete"), services.DeleteService)
+	service.Put("/:id/resources", middleware.Protected(), middleware.RequirePermission("services", "update"), services.UpdateServiceResources)
+
+	// Variants and add-ons that change a booking's duration and price
+	service.Get("/:id/options", services.GetServiceOptions)
+	service.Post("/:id/options", middleware.Protected(), middleware.RequirePermission("services", "update"), services.CreateServiceOption)
+	service.Patch("/:id/options/:option_id", middleware.Protected(), middleware.RequirePermission("services", "update"), services.UpdateServiceOption)
+	service.Delete("/:id/options/:option_id", middleware.Protected(), middleware.RequirePermission("services", "update"), services.DeleteServiceOption)
 
 	//_______________________________________________________________________________
 	dashboard := app.Group("provider/dashboard", middleware.Protected())
@@ -38,6 +45,17 @@ func SetupServiceRoutes(app *fiber.App) {
 	// All appointments
 	providerAppointments.Get("/", services.GetAllAppointments)
 
+	// Recurring occurrences that could not be booked
+	providerAppointments.Get("/flagged", services.GetFlaggedOccurrences)
+
+	// Sessions with their attendee lists
+	providerAppointments.Get("/sessions", services.GetSessionAttendees)
+
+	// Customers' proposals to move their appointments
+	providerAppointments.Get("/reschedule-requests", services.GetRescheduleRequests)
+	providerAppointments.Post("/reschedule-requests/:id/accept", middleware.RequirePermission("services", "update"), services.AcceptRescheduleRequest)
+	providerAppointments.Post("/reschedule-requests/:id/decline", middleware.RequirePermission("services", "update"), services.DeclineRescheduleRequest)
+
 	// Appointment details
 	providerAppointments.Get("/:id", services.GetAppointmentDetails)
 
@@ -50,6 +68,10 @@ func SetupServiceRoutes(app *fiber.App) {
 	// Appointment management
 	providerAppointments.Patch("/:id/status", middleware.RequirePermission("services", "update"), services.UpdateAppointmentStatus)
 	providerAppointments.Patch("/:id/reschedule", middleware.RequirePermission("services", "update"), services.RescheduleAppointment)
+	providerAppointments.Patch("/:id/series", middleware.RequirePermission("services", "update"), services.UpdateAppointmentSeries)
+
+	// Cancel or shift a whole day or period at once, e.g. when the provider falls ill
+	providerAppointments.Post("/bulk", middleware.RequirePermission("services", "update"), services.BulkUpdateAppointments)
 
 	//_____________________________________________________________________
 	profile := app.Group("/provider/profile", middleware.Protected())
@@ -65,15 +87,51 @@ func SetupServiceRoutes(app *fiber.App) {
 	profile.Get("/settings", services.GetProviderSettings)
 	profile.Patch("/settings", services.UpdateProviderSettings)
 
+	// Cancellation policy
+	profile.Get("/cancellation-policy", services.GetCancellationPolicy)
+	profile.Put("/cancellation-policy", middleware.RequirePermission("services", "update"), services.UpdateCancellationPolicy)
+
 	// Working hours
 	profile.Get("/working-hours", services.GetWorkingHours)
 	profile.Post("/working-hours", services.CreateWorkingHours)
 	profile.Patch("/working-hours", services.UpdateWorkingHours)
 
+	// Holidays, time off and custom hours for specific dates
+	profile.Get("/availability-overrides", services.GetAvailabilityOverrides)
+	profile.Post("/availability-overrides", services.CreateAvailabilityOverride)
+	profile.Patch("/availability-overrides/:id", services.UpdateAvailabilityOverride)
+	profile.Delete("/availability-overrides/:id", services.DeleteAvailabilityOverride)
+
 	//details for comsumer
 	profile.Get("/:id", services.GetProviderDetailsByID)
 	profile.Get("/services/:id", services.GetAllServicesByProviderID)
 
+	//_____________________________________________________________________
+	// Walk-in queue kept by the front desk
+	walkIns := app.Group("/provider/walk-ins", middleware.Protected())
+	walkIns.Get("/", services.GetWalkInQueue)
+	walkIns.Post("/", middleware.RequirePermission("services", "update"), services.AddWalkIn)
+	walkIns.Patch("/:id/status", middleware.RequirePermission("services", "update"), services.UpdateWalkInStatus)
+
+	//_____________________________________________________________________
+	staff := app.Group("/provider/staff", middleware.Protected())
+	staff.Get("/", services.GetStaff)
+	staff.Post("/", middleware.RequirePermission("services", "create"), services.CreateStaff)
+	staff.Patch("/:id", middleware.RequirePermission("services", "update"), services.UpdateStaff)
+	staff.Delete("/:id", middleware.RequirePermission("services", "delete"), services.DeleteStaff)
+
+	// Staff working hours; an empty schedule falls back to the business hours
+	staff.Get("/:id/working-hours", services.GetStaffWorkingHours)
+	staff.Put("/:id/working-hours", middleware.RequirePermission("services", "update"), services.UpdateStaffWorkingHours)
+
+	//_____________________________________________________________________
+	resources := app.Group("/provider/resources", middleware.Protected())
+	resources.Get("/", services.GetResources)
+	resources.Get("/utilisation", services.GetResourceUtilisation)
+	resources.Post("/", middleware.RequirePermission("services", "create"), services.CreateResource)
+	resources.Patch("/:id", middleware.RequirePermission("services", "update"), services.UpdateResource)
+	resources.Delete("/:id", middleware.RequirePermission("services", "delete"), services.DeleteResource)
+
 	receptionist := app.Group("/provider/receptionist", middleware.Protected())
 	// Create Receptionist
 	receptionist.Post("/", middleware.RequirePermission("services", "create"), services.CreateReceptionist)
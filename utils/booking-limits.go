@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// ErrBookingLimit is returned when a booking would exceed one of the provider's booking limits
+var ErrBookingLimit = errors.New("booking limit reached")
+
+// BookingLimitError names the limit a booking would exceed
+type BookingLimitError struct {
+	Limit   string `json:"limit"` // Setting that was hit, e.g. max_daily_appointments
+	Max     int    `json:"max"`
+	Message string `json:"message"`
+}
+
+func (e *BookingLimitError) Error() string {
+	return "booking limit reached: " + e.Message
+}
+
+func (e *BookingLimitError) Unwrap() error {
+	return ErrBookingLimit
+}
+
+// CheckBookingLimits rejects a booking that would exceed the provider's or the service's daily
+// limit, the customer's number of upcoming bookings, or the minimum gap between the customer's
+// bookings. req.StartTime must be in the provider's time zone so the day is the provider's.
+// An appointment being moved keeps its place and is not counted against itself.
+func CheckBookingLimits(tx *gorm.DB, settings *models.ProviderSettings, req SlotRequest) error {
+	dayStart := time.Date(req.StartTime.Year(), req.StartTime.Month(), req.StartTime.Day(), 0, 0, 0, 0, req.StartTime.Location())
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	sameDay := func() *gorm.DB {
+		return tx.Model(&models.Appointment{}).
+			Where("provider_id = ? AND id <> ? AND status IN ? AND start_time >= ? AND start_time < ?",
+				req.ProviderID, req.AppointmentID, models.ActiveStatuses, dayStart.UTC(), dayEnd.UTC())
+	}
+
+	if settings.MaxDailyAppointments > 0 {
+		var count int64
+		if err := sameDay().Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count appointments: %v", err)
+		}
+		if count >= int64(settings.MaxDailyAppointments) {
+			return &BookingLimitError{
+				Limit:   "max_daily_appointments",
+				Max:     settings.MaxDailyAppointments,
+				Message: fmt.Sprintf("the provider takes at most %d appointments per day", settings.MaxDailyAppointments),
+			}
+		}
+	}
+
+	var service models.Service
+	if err := tx.Select("id", "name", "max_daily_bookings").First(&service, req.ServiceID).Error; err != nil {
+		return fmt.Errorf("failed to load service: %v", err)
+	}
+	if service.MaxDailyBookings > 0 {
+		var count int64
+		if err := sameDay().Where("service_id = ?", req.ServiceID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count appointments: %v", err)
+		}
+		if count >= int64(service.MaxDailyBookings) {
+			return &BookingLimitError{
+				Limit:   "max_daily_bookings",
+				Max:     service.MaxDailyBookings,
+				Message: fmt.Sprintf("%s can be booked at most %d times per day", service.Name, service.MaxDailyBookings),
+			}
+		}
+	}
+
+	// Moving a booking does not add one, so only new bookings count against the customer. A
+	// visit counts as one booking however many services it has, including the visit being booked.
+	if settings.MaxCustomerBookings > 0 && req.AppointmentID == 0 {
+		query := tx.Model(&models.Appointment{}).
+			Where("provider_id = ? AND customer_id = ? AND status IN ? AND start_time > ?", req.ProviderID, req.CustomerID,
+				[]models.AppointmentStatus{models.StatusPending, models.StatusConfirmed}, time.Now().UTC())
+		if req.VisitID != nil {
+			query = query.Where("visit_id IS DISTINCT FROM ?", *req.VisitID)
+		}
+		// Appointments outside a visit count by their negated ID so they never match a visit ID
+		var count int64
+		if err := query.Select("COUNT(DISTINCT COALESCE(visit_id, -id))").Scan(&count).Error; err != nil {
+			return fmt.Errorf("failed to count appointments: %v", err)
+		}
+		if count >= int64(settings.MaxCustomerBookings) {
+			return &BookingLimitError{
+				Limit:   "max_customer_bookings",
+				Max:     settings.MaxCustomerBookings,
+				Message: fmt.Sprintf("customers can have at most %d upcoming bookings with this provider", settings.MaxCustomerBookings),
+			}
+		}
+	}
+
+	// Services of the same visit are booked back to back on purpose
+	if settings.MinCustomerGapMinutes > 0 {
+		gap := time.Duration(settings.MinCustomerGapMinutes) * time.Minute
+		query := tx.Model(&models.Appointment{}).
+			Where("provider_id = ? AND customer_id = ? AND id <> ? AND status IN ?", req.ProviderID, req.CustomerID,
+				req.AppointmentID, models.ActiveStatuses).
+			Where("end_time > ? AND start_time < ?", req.StartTime.Add(-gap).UTC(), req.StartTime.Add(req.Duration+gap).UTC())
+		if req.VisitID != nil {
+			query = query.Where("visit_id IS DISTINCT FROM ?", *req.VisitID)
+		}
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count appointments: %v", err)
+		}
+		if count > 0 {
+			return &BookingLimitError{
+				Limit:   "min_customer_gap_minutes",
+				Max:     settings.MinCustomerGapMinutes,
+				Message: fmt.Sprintf("bookings by the same customer must be at least %d minutes apart", settings.MinCustomerGapMinutes),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// providerScheduleLock namespaces the advisory locks taken on a provider's calendar
+const providerScheduleLock int32 = 1001
+
+var (
+	// ErrSlotUnavailable is returned when the requested time overlaps another booking
+	ErrSlotUnavailable = errors.New("time slot not available")
+	// ErrOutsideWorkingHours is returned when the requested time is outside the provider's working hours
+	ErrOutsideWorkingHours = errors.New("appointment is outside working hours or during break")
+	// ErrProviderClosed is returned when the requested time falls in the provider's closing period
+	ErrProviderClosed = errors.New("provider is closed")
+	// ErrSlotHeld is returned when the requested time is held for another customer
+	ErrSlotHeld = errors.New("time slot is on hold for another customer")
+	// ErrSessionFull is returned when every seat of a group session is taken
+	ErrSessionFull = errors.New("session is fully booked")
+	// ErrBeyondBookingWindow is returned when the requested time is further ahead than the provider accepts
+	ErrBeyondBookingWindow = errors.New("appointment is beyond the advance booking window")
+	// ErrNoStaffAvailable is returned when "any available" finds no free staff member for the slot
+	ErrNoStaffAvailable = fmt.Errorf("%w: no staff member is available", ErrSlotUnavailable)
+)
+
+// SlotConflictError describes the appointment that already occupies a requested slot
+type SlotConflictError struct {
+	AppointmentID uint      `json:"appointment_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+}
+
+func (e *SlotConflictError) Error() string {
+	return fmt.Sprintf("time slot not available: overlaps appointment %d (%s - %s)",
+		e.AppointmentID, e.StartTime.Format("2006-01-02 15:04"), e.EndTime.Format("2006-01-02 15:04"))
+}
+
+func (e *SlotConflictError) Unwrap() error {
+	return ErrSlotUnavailable
+}
+
+// ProviderClosedError carries the provider's closing period and remarks
+type ProviderClosedError struct {
+	From    time.Time `json:"from"`
+	Until   time.Time `json:"until"`
+	Remarks string    `json:"remarks"`
+}
+
+func (e *ProviderClosedError) Error() string {
+	msg := fmt.Sprintf("provider is closed from %s to %s",
+		e.From.Format("2006-01-02 15:04"), e.Until.Format("2006-01-02 15:04"))
+	if e.Remarks != "" {
+		msg += ": " + e.Remarks
+	}
+	return msg
+}
+
+func (e *ProviderClosedError) Unwrap() error {
+	return ErrProviderClosed
+}
+
+// SlotRequest describes the time a booking wants to occupy on a provider's calendar
+type SlotRequest struct {
+	ProviderID    uint
+	ServiceID     uint
+	StaffID       *uint // Staff member whose calendar is booked, nil for the provider's own calendar
+	CustomerID    uint  // Customer booking the slot; their own holds do not block it
+	Capacity      int   // Seats per session; group sessions share the slot until full
+	StartTime     time.Time
+	Duration      time.Duration
+	BufferTime    time.Duration
+	AppointmentID uint  // Appointment being moved, ignored during the overlap check
+	VisitID       *uint // Visit being booked; its other services are exempt from the customer gap
+}
+
+// LockProviderSchedule serializes bookings for a provider until the transaction ends
+func LockProviderSchedule(tx *gorm.DB, providerID uint) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", providerScheduleLock, int32(providerID)).Error
+}
+
+// LoadProviderSettings returns the provider's settings, or the defaults when none were saved
+func LoadProviderSettings(tx *gorm.DB, providerID uint) (*models.ProviderSettings, error) {
+	var settings models.ProviderSettings
+	err := tx.Where("provider_id = ?", providerID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.ProviderSettings{ProviderID: providerID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider settings: %v", err)
+	}
+	return &settings, nil
+}
+
+// CheckProviderSettings rejects bookings during the provider's closure or beyond their
+// advance booking window
+func CheckProviderSettings(settings *models.ProviderSettings, start, end, now time.Time) error {
+	if settings.IsClosedDuring(start, end) {
+		return &ProviderClosedError{
+			From:    settings.ClosingStartDate,
+			Until:   settings.ClosingEndDate,
+			Remarks: settings.ClosingRemarks,
+		}
+	}
+	if windowEnd, ok := settings.BookingWindowEnd(now); ok && start.After(windowEnd) {
+		return fmt.Errorf("%w: bookings are accepted up to %d days ahead", ErrBeyondBookingWindow, settings.AdvanceBookingDays)
+	}
+	return nil
+}
+
+// ReserveSlot locks the provider's schedule and checks the provider's settings, booking
+// limits, working hours, existing bookings, walk-ins being served and resources inside tx. The lock is held
+// until tx ends, so the caller must create or update the appointment in the same transaction.
+func ReserveSlot(tx *gorm.DB, req SlotRequest) error {
+	if err := LockProviderSchedule(tx, req.ProviderID); err != nil {
+		return fmt.Errorf("failed to lock provider schedule: %v", err)
+	}
+
+	settings, err := LoadProviderSettings(tx, req.ProviderID)
+	if err != nil {
+		return err
+	}
+
+	// Working hours are wall-clock times in the provider's time zone
+	req.StartTime = req.StartTime.In(LoadTimeZone(settings.TimeZone))
+	if err := CheckProviderSettings(settings, req.StartTime, req.StartTime.Add(req.Duration), time.Now()); err != nil {
+		return err
+	}
+	if err := CheckBookingLimits(tx, settings, req); err != nil {
+		return err
+	}
+
+	isWorkingHour, err := CheckWorkingDayAndHours(req.ProviderID, req.StaffID, req.StartTime, req.Duration)
+	if err != nil {
+		return err
+	}
+	if !isWorkingHour {
+		return ErrOutsideWorkingHours
+	}
+
+	if err := CheckWaitlistHolds(tx, req.ProviderID, req.StaffID, req.CustomerID, req.StartTime, req.StartTime.Add(req.Duration+req.BufferTime)); err != nil {
+		return err
+	}
+
+	// Checkout holds count as occupied for everyone but the customer holding the slot
+	seatsLeft := 1
+	if req.Capacity > 1 {
+		seatsLeft, err = CheckSessionAvailability(tx, req.ProviderID, req.ServiceID, req.StaffID, req.Capacity,
+			req.StartTime, req.Duration+req.BufferTime, req.AppointmentID)
+		if err != nil {
+			return err
+		}
+	} else if err := CheckAvailability(tx, req.ProviderID, req.StaffID, req.StartTime, req.Duration+req.BufferTime, req.AppointmentID); err != nil {
+		return err
+	}
+	if err := CheckSlotHolds(req, seatsLeft); err != nil {
+		return err
+	}
+	if err := CheckWalkIns(tx, req); err != nil {
+		return err
+	}
+
+	// Rooms, chairs and equipment are shared by the whole business
+	return CheckResources(tx, req)
+}
+
+// IsBookingConflict reports whether err means the requested slot cannot be booked
+func IsBookingConflict(err error) bool {
+	return errors.Is(err, ErrSlotUnavailable) || errors.Is(err, ErrOutsideWorkingHours) ||
+		errors.Is(err, ErrProviderClosed) || errors.Is(err, ErrBeyondBookingWindow) ||
+		errors.Is(err, ErrSessionFull) || errors.Is(err, ErrSlotHeld) ||
+		errors.Is(err, ErrResourceUnavailable) || errors.Is(err, ErrBookingLimit) ||
+		errors.Is(err, ErrCustomerDoubleBooked)
+}
+
+// BookingErrorMessage summarizes why a booking conflict was rejected
+func BookingErrorMessage(err error) string {
+	var closed *ProviderClosedError
+	var shortage *ResourceShortageError
+	var limit *BookingLimitError
+	switch {
+	case errors.As(err, &closed):
+		if closed.Remarks != "" {
+			return "Provider is closed: " + closed.Remarks
+		}
+		return "Provider is closed"
+	case errors.Is(err, ErrBeyondBookingWindow):
+		return "Appointment is too far in advance"
+	case errors.As(err, &limit):
+		return "Booking limit reached: " + limit.Message
+	case errors.Is(err, ErrOutsideWorkingHours):
+		return "Appointment is outside working hours"
+	case errors.Is(err, ErrSessionFull):
+		return "Session is fully booked"
+	case errors.Is(err, ErrSlotHeld):
+		return "Time slot is on hold"
+	case errors.Is(err, ErrCustomerDoubleBooked):
+		return "You already have an appointment at this time"
+	case errors.As(err, &shortage):
+		return shortage.Name + " is not available at this time"
+	case errors.Is(err, ErrNoStaffAvailable):
+		return "No staff member is available at this time"
+	default:
+		return "Time slot not available"
+	}
+}
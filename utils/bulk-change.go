@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// maxBulkChangeDays is the longest period a single bulk change may cover
+const maxBulkChangeDays = 31
+
+// ErrInvalidBulkChange is returned when a bulk change request cannot be carried out as given
+var ErrInvalidBulkChange = errors.New("invalid bulk change")
+
+// BulkAction is what a bulk change does to each affected appointment
+type BulkAction string
+
+const (
+	BulkCancel BulkAction = "cancel"
+	BulkShift  BulkAction = "shift"
+)
+
+// BulkChange cancels or shifts every pending and confirmed appointment of a provider, or of one
+// of their staff members, that starts within [From, To)
+type BulkChange struct {
+	ProviderID    uint
+	StaffID       *uint // Only this staff member's appointments, nil for all of them
+	From          time.Time
+	To            time.Time
+	Action        BulkAction
+	ShiftBy       time.Duration // How far shifted appointments move
+	CancelUnmoved bool          // Cancel appointments that cannot be shifted instead of leaving them
+	Reason        string
+	Actor         models.Actor
+}
+
+// BulkChangeResult is the outcome of a bulk change for one appointment. Alternatives are
+// offered to the customer whenever the appointment did not keep a time.
+type BulkChangeResult struct {
+	OccurrenceResult
+	CustomerID   uint        `json:"customer_id"`
+	NewStartTime *time.Time  `json:"new_start_time,omitempty"`
+	Alternatives []time.Time `json:"alternatives,omitempty"`
+}
+
+// BulkChangeReport summarizes a bulk change
+type BulkChangeReport struct {
+	Action            BulkAction         `json:"action"`
+	From              time.Time          `json:"from"`
+	To                time.Time          `json:"to"`
+	Affected          int                `json:"affected"`
+	Succeeded         int                `json:"succeeded"`
+	Failed            int                `json:"failed"`
+	CustomersNotified int                `json:"customers_notified"`
+	Results           []BulkChangeResult `json:"results"`
+}
+
+// Validate checks the period, action and shift of the change
+func (b *BulkChange) Validate() error {
+	if !b.To.After(b.From) {
+		return fmt.Errorf("%w: the end of the period must be after its start", ErrInvalidBulkChange)
+	}
+	if b.To.Sub(b.From) > maxBulkChangeDays*24*time.Hour {
+		return fmt.Errorf("%w: the period cannot be longer than %d days", ErrInvalidBulkChange, maxBulkChangeDays)
+	}
+	switch b.Action {
+	case BulkCancel:
+	case BulkShift:
+		if b.ShiftBy == 0 {
+			return fmt.Errorf("%w: shifting needs a non-zero shift", ErrInvalidBulkChange)
+		}
+	default:
+		return fmt.Errorf("%w: action must be 'cancel' or 'shift'", ErrInvalidBulkChange)
+	}
+	return nil
+}
+
+// ApplyBulkChange carries out the change on every affected appointment on its own, so one
+// failure does not stop the rest. Customers whose appointment was canceled or could not be
+// moved get the nearest free times after the period; each customer gets a single email
+// covering all their appointments.
+func ApplyBulkChange(change BulkChange) (*BulkChangeReport, error) {
+	if err := change.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Shifting later starts from the last appointment so each one moves into time the ones
+	// after it have already freed, and the other way round when shifting earlier
+	order := "start_time asc"
+	if change.Action == BulkShift && change.ShiftBy > 0 {
+		order = "start_time desc"
+	}
+	query := db.DB.Preload("Service").Preload("Customer").
+		Where("provider_id = ? AND status IN ? AND start_time >= ? AND start_time < ?", change.ProviderID,
+			[]models.AppointmentStatus{models.StatusPending, models.StatusConfirmed}, change.From.UTC(), change.To.UTC())
+	if change.StaffID != nil {
+		query = query.Where("staff_id = ?", *change.StaffID)
+	}
+	var appointments []models.Appointment
+	if err := query.Order(order).Find(&appointments).Error; err != nil {
+		return nil, fmt.Errorf("failed to load appointments: %v", err)
+	}
+
+	report := &BulkChangeReport{
+		Action:   change.Action,
+		From:     change.From,
+		To:       change.To,
+		Affected: len(appointments),
+		Results:  make([]BulkChangeResult, 0, len(appointments)),
+	}
+	now := time.Now()
+	searchFrom := change.To
+	if searchFrom.Before(now) {
+		searchFrom = now
+	}
+
+	for i := range appointments {
+		appointment := &appointments[i]
+		result := BulkChangeResult{
+			OccurrenceResult: OccurrenceResult{AppointmentID: appointment.ID, StartTime: appointment.StartTime, EndTime: appointment.EndTime},
+			CustomerID:       appointment.CustomerID,
+		}
+
+		if change.Action == BulkShift {
+			warnings, err := shiftAppointment(appointment, change, now)
+			result.Warnings = warnings
+			if err != nil {
+				result.Status = OccurrenceFailed
+				if IsBookingConflict(err) {
+					result.Status = OccurrenceConflict
+				}
+				result.Error = err.Error()
+			} else {
+				result.Status = OccurrenceRescheduled
+				result.NewStartTime = &appointment.StartTime
+			}
+		}
+		if change.Action == BulkCancel || (result.Status != OccurrenceRescheduled && change.CancelUnmoved) {
+			if err := appointment.UpdateStatus(db.DB, models.StatusCanceled, change.Actor, change.Reason); err != nil {
+				result.Status = OccurrenceFailed
+				result.Error = err.Error()
+			} else {
+				result.Status = OccurrenceCanceled
+			}
+		}
+
+		if result.Status == OccurrenceRescheduled || result.Status == OccurrenceCanceled {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		if result.Status != OccurrenceRescheduled {
+			alternatives, err := SuggestAlternatives(appointment, searchFrom, alternativeSuggestions)
+			if err != nil {
+				log.Printf("Failed to find alternatives for appointment %d: %v", appointment.ID, err)
+			}
+			result.Alternatives = alternatives
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	report.CustomersNotified = notifyBulkChange(change, appointments, report.Results)
+	return report, nil
+}
+
+// shiftAppointment moves the appointment by the change's shift through the booking engine and
+// returns any clashes with the customer's other appointments as warnings
+func shiftAppointment(appointment *models.Appointment, change BulkChange, now time.Time) ([]string, error) {
+	start := appointment.StartTime.Add(change.ShiftBy).UTC()
+	if !start.After(now) {
+		return nil, fmt.Errorf("%w: the shifted time has already passed", ErrSlotUnavailable)
+	}
+	var warnings []string
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ReserveSlot(tx, SlotRequest{
+			ProviderID:    appointment.ProviderID,
+			ServiceID:     appointment.ServiceID,
+			StaffID:       appointment.StaffID,
+			CustomerID:    appointment.CustomerID,
+			Capacity:      appointment.Service.Seats(),
+			StartTime:     start,
+			Duration:      appointment.Duration(),
+			BufferTime:    appointment.Service.BufferTime,
+			AppointmentID: appointment.ID,
+		}); err != nil {
+			return err
+		}
+		var err error
+		warnings, err = CheckCustomerOverlaps(tx, CustomerBooking{
+			CustomerID:    appointment.CustomerID,
+			ProviderID:    appointment.ProviderID,
+			StartTime:     start,
+			EndTime:       start.Add(appointment.Duration()),
+			AppointmentID: appointment.ID,
+		})
+		if err != nil {
+			return err
+		}
+
+		previous := appointment.StartTime
+		duration := appointment.Duration()
+		if err := tx.Model(appointment).Updates(map[string]interface{}{
+			"start_time": start,
+			"end_time":   start.Add(duration),
+		}).Error; err != nil {
+			return err
+		}
+		appointment.StartTime = start
+		appointment.EndTime = start.Add(duration)
+		return models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventRescheduled,
+			Field:         "start_time",
+			OldValue:      models.EventTime(previous),
+			NewValue:      models.EventTime(start),
+			Reason:        change.Reason,
+		}, change.Actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+// notifyBulkChange sends each affected customer one email listing what happened to each of
+// their appointments, and returns how many customers were emailed
+func notifyBulkChange(change BulkChange, appointments []models.Appointment, results []BulkChangeResult) int {
+	var provider models.User
+	if err := db.DB.First(&provider, change.ProviderID).Error; err != nil {
+		log.Printf("Failed to load provider %d: %v", change.ProviderID, err)
+		return 0
+	}
+	loc := ProviderLocation(change.ProviderID)
+
+	customers := map[uint]models.User{}
+	var order []uint
+	items := map[uint]*strings.Builder{}
+	for i, result := range results {
+		customer := appointments[i].Customer
+		if _, ok := items[customer.ID]; !ok {
+			customers[customer.ID] = customer
+			order = append(order, customer.ID)
+			items[customer.ID] = &strings.Builder{}
+		}
+
+		var line string
+		switch result.Status {
+		case OccurrenceRescheduled:
+			line = fmt.Sprintf("%s on %s has moved to %s.", appointments[i].Service.Name,
+				FormatInZone(result.StartTime, loc), FormatInZone(*result.NewStartTime, loc))
+		case OccurrenceCanceled:
+			line = fmt.Sprintf("%s on %s has been canceled.", appointments[i].Service.Name, FormatInZone(result.StartTime, loc))
+		default:
+			line = fmt.Sprintf("%s on %s could not be changed yet; we will be in touch about it.", appointments[i].Service.Name,
+				FormatInZone(result.StartTime, loc))
+		}
+		if len(result.Alternatives) > 0 {
+			var times []string
+			for _, alternative := range result.Alternatives {
+				times = append(times, FormatInZone(alternative, loc))
+			}
+			line += " Times still available: " + strings.Join(times, ", ") + "."
+		}
+		items[customer.ID].WriteString("<li>" + line + "</li>")
+	}
+
+	reason := ""
+	if change.Reason != "" {
+		reason = fmt.Sprintf("<p>%s</p>", html.EscapeString(change.Reason))
+	}
+	notified := 0
+	for _, id := range order {
+		customer := customers[id]
+		body := fmt.Sprintf(`
+			<p>Dear %s,</p>
+			<p>%s had to change their schedule, which affects your appointments:</p>
+			%s
+			<ul>%s</ul>
+			<p>We apologize for the inconvenience.</p>
+			<p>Best regards,</p>
+			<p>Your Appointment Team</p>
+		`, customer.Name, provider.Name, reason, items[id].String())
+		if err := SendEmail(customer.Email, "Changes to Your Appointments", body); err != nil {
+			log.Printf("Failed to send bulk change email to customer %d: %v", id, err)
+			continue
+		}
+		notified++
+	}
+	return notified
+}
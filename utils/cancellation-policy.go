@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// ErrPolicyViolation is returned when the provider's cancellation policy refuses a change
+var ErrPolicyViolation = errors.New("not allowed by the cancellation policy")
+
+// PolicyAction is a change a customer makes to a booking
+type PolicyAction string
+
+const (
+	PolicyCancel     PolicyAction = "cancel"
+	PolicyReschedule PolicyAction = "reschedule"
+	PolicyDelete     PolicyAction = "delete"
+)
+
+// PolicyRule names the rule of the policy that decided a change
+type PolicyRule string
+
+const (
+	RuleWithinPolicy     PolicyRule = "within_policy"
+	RuleLateCancellation PolicyRule = "late_cancellation"
+	RuleMinimumNotice    PolicyRule = "minimum_notice"
+	RuleNoCancelWindow   PolicyRule = "no_cancel_window"
+	RuleRescheduleLimit  PolicyRule = "reschedule_limit"
+)
+
+// PolicyDecision explains whether a change is allowed, which rule applied and the fee charged
+type PolicyDecision struct {
+	Action      PolicyAction `json:"action"`
+	Allowed     bool         `json:"allowed"`
+	Rule        PolicyRule   `json:"rule"`
+	Fee         float64      `json:"fee"`
+	Explanation string       `json:"explanation"`
+}
+
+// PolicyError carries the decision of a refused change
+type PolicyError struct {
+	Decision PolicyDecision
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Decision.Action, ErrPolicyViolation, e.Decision.Explanation)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrPolicyViolation
+}
+
+// LoadCancellationPolicy returns the provider's policy, or an empty one that allows everything
+func LoadCancellationPolicy(tx *gorm.DB, providerID uint) (*models.CancellationPolicy, error) {
+	var policy models.CancellationPolicy
+	err := tx.Where("provider_id = ?", providerID).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.CancellationPolicy{ProviderID: providerID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cancellation policy: %v", err)
+	}
+	return &policy, nil
+}
+
+// EvaluatePolicy decides whether the customer may make the change now. Nothing can be changed
+// inside the no-cancel window; reschedules need the minimum notice and must stay under the
+// limit; cancellations and deletions with less than the minimum notice are charged the late fee.
+func EvaluatePolicy(policy *models.CancellationPolicy, appointment *models.Appointment, action PolicyAction, now time.Time) PolicyDecision {
+	decision := PolicyDecision{Action: action, Allowed: true, Rule: RuleWithinPolicy}
+	notice := appointment.StartTime.Sub(now)
+
+	if policy.NoCancelHours > 0 && notice < time.Duration(policy.NoCancelHours)*time.Hour {
+		decision.Allowed = false
+		decision.Rule = RuleNoCancelWindow
+		decision.Explanation = fmt.Sprintf("Bookings cannot be canceled, rescheduled or deleted less than %d hours before they start", policy.NoCancelHours)
+		return decision
+	}
+
+	late := policy.MinNoticeHours > 0 && notice < time.Duration(policy.MinNoticeHours)*time.Hour
+	switch action {
+	case PolicyReschedule:
+		if policy.MaxReschedules > 0 && appointment.RescheduleCount >= policy.MaxReschedules {
+			decision.Allowed = false
+			decision.Rule = RuleRescheduleLimit
+			decision.Explanation = fmt.Sprintf("This booking has already been rescheduled %d times, the most allowed", appointment.RescheduleCount)
+			return decision
+		}
+		if late {
+			decision.Allowed = false
+			decision.Rule = RuleMinimumNotice
+			decision.Explanation = fmt.Sprintf("Bookings can only be rescheduled at least %d hours before they start", policy.MinNoticeHours)
+			return decision
+		}
+		decision.Explanation = "Rescheduled free of charge"
+	default:
+		if late && policy.LateCancelFeePercent > 0 {
+			decision.Rule = RuleLateCancellation
+			decision.Fee = math.Round(appointment.TotalAmount*policy.LateCancelFeePercent) / 100
+			decision.Explanation = fmt.Sprintf("Canceled with less than %d hours notice, a late cancellation fee of %g%% (%.2f) applies",
+				policy.MinNoticeHours, policy.LateCancelFeePercent, decision.Fee)
+			return decision
+		}
+		decision.Explanation = "Canceled free of charge"
+	}
+	return decision
+}
+
+// CheckPolicy evaluates the provider's policy for the change and returns a *PolicyError when
+// it is refused
+func CheckPolicy(tx *gorm.DB, appointment *models.Appointment, action PolicyAction) (*PolicyDecision, error) {
+	policy, err := LoadCancellationPolicy(tx, appointment.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+	decision := EvaluatePolicy(policy, appointment, action, time.Now())
+	if !decision.Allowed {
+		return &decision, &PolicyError{Decision: decision}
+	}
+	return &decision, nil
+}
+
+// RecordCancellationFee charges the late fee of a policy decision on the appointment and keeps
+// the charge, with the rule that caused it, in the appointment's history
+func RecordCancellationFee(tx *gorm.DB, appointment *models.Appointment, decision *PolicyDecision, actor models.Actor) error {
+	if decision.Fee <= 0 {
+		return nil
+	}
+	previous := appointment.CancellationFee
+	appointment.CancellationFee = decision.Fee
+	if err := tx.Model(appointment).Update("cancellation_fee", decision.Fee).Error; err != nil {
+		return err
+	}
+	return models.RecordEvent(tx, models.AppointmentEvent{
+		AppointmentID: appointment.ID,
+		Type:          models.EventUpdated,
+		Field:         "cancellation_fee",
+		OldValue:      fmt.Sprintf("%.2f", previous),
+		NewValue:      fmt.Sprintf("%.2f", decision.Fee),
+		Reason:        decision.Explanation,
+	}, actor)
+}
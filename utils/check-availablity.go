@@ -3,40 +3,92 @@ package utils
 import (
 	"time"
 
-	"github.com/meinhoongagan/appointment-app/db"
 	"github.com/meinhoongagan/appointment-app/models"
 	"gorm.io/gorm"
 )
 
-// CheckAvailability checks if a provider is available for a given time slot, including buffer time
-func CheckAvailability(providerID uint, startTime time.Time, totalDuration time.Duration) (bool, error) {
-	// Convert startTime and endTime to IST before checking
-	startTimeIST := ToIST(startTime)
-	endTimeIST := ToIST(startTime.Add(totalDuration)) // totalDuration includes Duration + BufferTime
+// CheckAvailability checks if a provider is available for a given time slot, including buffer time.
+// It returns a *SlotConflictError when another pending or confirmed appointment overlaps the slot.
+// Only the calendar of staffID is checked, or the provider's own calendar when staffID is nil.
+// excludeID skips the appointment being moved so it does not conflict with itself.
+func CheckAvailability(tx *gorm.DB, providerID uint, staffID *uint, startTime time.Time, totalDuration time.Duration, excludeID uint) error {
+	// Compare in UTC, which is how appointment times are stored
+	startTimeUTC := startTime.UTC()
+	endTimeUTC := startTime.Add(totalDuration).UTC() // totalDuration includes Duration + BufferTime
 
 	// Check if any conflicting appointments exist and lock them
 	var existingAppointment models.Appointment
-	err := db.DB.Raw(`
+	err := tx.Raw(`
 		SELECT *
 		FROM appointments
-		WHERE provider_id = ? AND status != ? AND (
-			(start_time < ? AND end_time > ?) OR
-			(start_time >= ? AND start_time < ?)
-		)
+		WHERE provider_id = ? AND staff_id IS NOT DISTINCT FROM ? AND id != ? AND deleted_at IS NULL AND status IN ? AND
+			start_time < ? AND end_time > ?
+		LIMIT 1
 		FOR UPDATE
-	`, providerID, models.StatusCompleted, endTimeIST, startTimeIST, startTimeIST, endTimeIST).
-		First(&existingAppointment).Error
-
-	// If there is a conflicting appointment (excluding completed), return false
-	if err == nil && existingAppointment.ID != 0 {
-		return false, nil
+	`, providerID, staffID, excludeID, models.ActiveStatuses,
+		endTimeUTC, startTimeUTC).
+		Scan(&existingAppointment).Error
+	if err != nil {
+		return err
 	}
 
-	// Handle database errors
-	if err != nil && err != gorm.ErrRecordNotFound {
-		return false, err
+	// If there is a conflicting appointment, report it
+	if existingAppointment.ID != 0 {
+		return &SlotConflictError{
+			AppointmentID: existingAppointment.ID,
+			StartTime:     existingAppointment.StartTime,
+			EndTime:       existingAppointment.EndTime,
+		}
 	}
 
 	// No conflict, slot is available
-	return true, nil
+	return nil
+}
+
+// CheckSessionAvailability checks a seat in a group session. Other bookings of the same
+// service at the same start time share the slot; any other overlap is a conflict. It
+// returns the seats left before this booking, or ErrSessionFull when none are left.
+func CheckSessionAvailability(tx *gorm.DB, providerID, serviceID uint, staffID *uint, capacity int, startTime time.Time, totalDuration time.Duration, excludeID uint) (int, error) {
+	startTimeUTC := startTime.UTC()
+	endTimeUTC := startTime.Add(totalDuration).UTC()
+	active := models.ActiveStatuses
+
+	// Anything overlapping that is not part of this session blocks it
+	var existingAppointment models.Appointment
+	err := tx.Raw(`
+		SELECT *
+		FROM appointments
+		WHERE provider_id = ? AND staff_id IS NOT DISTINCT FROM ? AND id != ? AND deleted_at IS NULL AND status IN ? AND
+			start_time < ? AND end_time > ? AND
+			NOT (service_id = ? AND start_time = ?)
+		LIMIT 1
+		FOR UPDATE
+	`, providerID, staffID, excludeID, active, endTimeUTC, startTimeUTC, serviceID, startTimeUTC).
+		Scan(&existingAppointment).Error
+	if err != nil {
+		return 0, err
+	}
+	if existingAppointment.ID != 0 {
+		return 0, &SlotConflictError{
+			AppointmentID: existingAppointment.ID,
+			StartTime:     existingAppointment.StartTime,
+			EndTime:       existingAppointment.EndTime,
+		}
+	}
+
+	// Count the seats already taken in the session
+	var booked int64
+	if err := tx.Model(&models.Appointment{}).
+		Where("provider_id = ? AND service_id = ? AND start_time = ? AND id != ? AND status IN ?",
+			providerID, serviceID, startTimeUTC, excludeID, active).
+		Where("staff_id IS NOT DISTINCT FROM ?", staffID).
+		Count(&booked).Error; err != nil {
+		return 0, err
+	}
+
+	remaining := capacity - int(booked)
+	if remaining <= 0 {
+		return 0, ErrSessionFull
+	}
+	return remaining, nil
 }
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// customerScheduleLock namespaces the advisory locks taken on a customer's bookings
+const customerScheduleLock int32 = 1002
+
+// DoubleBookingPolicy is what happens when a customer books over one of their own appointments
+type DoubleBookingPolicy string
+
+const (
+	DoubleBookingWarn   DoubleBookingPolicy = "warn"   // Book anyway and warn the customer
+	DoubleBookingReject DoubleBookingPolicy = "reject" // Refuse the booking
+)
+
+// ErrCustomerDoubleBooked is returned when the customer already has an appointment at the requested time
+var ErrCustomerDoubleBooked = errors.New("customer already has an appointment at this time")
+
+// CustomerDoubleBookingPolicy returns the platform's policy, configurable via DOUBLE_BOOKING_POLICY.
+// Customers are warned unless it is set to "reject".
+func CustomerDoubleBookingPolicy() DoubleBookingPolicy {
+	if DoubleBookingPolicy(strings.ToLower(os.Getenv("DOUBLE_BOOKING_POLICY"))) == DoubleBookingReject {
+		return DoubleBookingReject
+	}
+	return DoubleBookingWarn
+}
+
+// CustomerTravelPadding returns the time a customer needs between appointments with different
+// providers, configurable via CUSTOMER_TRAVEL_MINUTES
+func CustomerTravelPadding() time.Duration {
+	minutes := 0
+	if v, err := strconv.Atoi(os.Getenv("CUSTOMER_TRAVEL_MINUTES")); err == nil && v > 0 {
+		minutes = v
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// CustomerBooking is the time a customer wants to spend with a provider
+type CustomerBooking struct {
+	CustomerID    uint
+	ProviderID    uint
+	StartTime     time.Time
+	EndTime       time.Time
+	AppointmentID uint  // Appointment being moved, not compared with itself
+	VisitID       *uint // Visit being booked, its own services do not count
+}
+
+// CustomerOverlap is one of the customer's appointments that clashes with a booking
+type CustomerOverlap struct {
+	AppointmentID uint      `json:"appointment_id"`
+	ProviderID    uint      `json:"provider_id"`
+	ProviderName  string    `json:"provider_name"`
+	Title         string    `json:"title"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	TravelOnly    bool      `json:"travel_only"` // Does not overlap but leaves too little time to travel
+}
+
+// Warning describes the clash for the customer, in the other provider's time zone
+func (o CustomerOverlap) Warning() string {
+	loc := ProviderLocation(o.ProviderID)
+	if o.TravelOnly {
+		return fmt.Sprintf("Leaves less than %d minutes to travel to or from your appointment '%s' with %s at %s",
+			int(CustomerTravelPadding()/time.Minute), o.Title, o.ProviderName, FormatInZone(o.StartTime, loc))
+	}
+	return fmt.Sprintf("Overlaps your appointment '%s' with %s from %s to %s",
+		o.Title, o.ProviderName, FormatInZone(o.StartTime, loc), FormatInZone(o.EndTime, loc))
+}
+
+// CustomerOverlapError lists the appointments a rejected booking clashes with
+type CustomerOverlapError struct {
+	Overlaps []CustomerOverlap `json:"overlaps"`
+}
+
+func (e *CustomerOverlapError) Error() string {
+	first := e.Overlaps[0]
+	return fmt.Sprintf("customer already has an appointment at this time: appointment %d with %s (%s - %s)",
+		first.AppointmentID, first.ProviderName, first.StartTime.Format("2006-01-02 15:04"), first.EndTime.Format("2006-01-02 15:04"))
+}
+
+func (e *CustomerOverlapError) Unwrap() error {
+	return ErrCustomerDoubleBooked
+}
+
+// FindCustomerOverlaps returns the customer's pending and confirmed appointments, with any
+// provider, that overlap the booking. Appointments with other providers also clash when they
+// leave less than the travel padding in between.
+func FindCustomerOverlaps(tx *gorm.DB, booking CustomerBooking) ([]CustomerOverlap, error) {
+	padding := CustomerTravelPadding()
+	query := tx.Preload("Provider").
+		Where("customer_id = ? AND id <> ? AND status IN ?", booking.CustomerID, booking.AppointmentID,
+			[]models.AppointmentStatus{models.StatusPending, models.StatusConfirmed}).
+		Where("end_time > ? AND start_time < ?", booking.StartTime.Add(-padding).UTC(), booking.EndTime.Add(padding).UTC())
+	if booking.VisitID != nil {
+		query = query.Where("visit_id IS DISTINCT FROM ?", *booking.VisitID)
+	}
+	var appointments []models.Appointment
+	if err := query.Order("start_time asc").Find(&appointments).Error; err != nil {
+		return nil, fmt.Errorf("failed to load customer appointments: %v", err)
+	}
+
+	var overlaps []CustomerOverlap
+	for _, appt := range appointments {
+		overlapping := appt.StartTime.Before(booking.EndTime) && appt.EndTime.After(booking.StartTime)
+		// No travel between two appointments at the same place
+		if !overlapping && appt.ProviderID == booking.ProviderID {
+			continue
+		}
+		overlaps = append(overlaps, CustomerOverlap{
+			AppointmentID: appt.ID,
+			ProviderID:    appt.ProviderID,
+			ProviderName:  appt.Provider.Name,
+			Title:         appt.Title,
+			StartTime:     appt.StartTime,
+			EndTime:       appt.EndTime,
+			TravelOnly:    !overlapping,
+		})
+	}
+	return overlaps, nil
+}
+
+// CheckCustomerOverlaps locks the customer's bookings until tx ends and looks for clashes with
+// the booking. Under the reject policy a clash fails with a *CustomerOverlapError; otherwise the
+// clashes are returned as warnings for the customer.
+func CheckCustomerOverlaps(tx *gorm.DB, booking CustomerBooking) ([]string, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", customerScheduleLock, int32(booking.CustomerID)).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock customer schedule: %v", err)
+	}
+	overlaps, err := FindCustomerOverlaps(tx, booking)
+	if err != nil || len(overlaps) == 0 {
+		return nil, err
+	}
+	if CustomerDoubleBookingPolicy() == DoubleBookingReject {
+		return nil, &CustomerOverlapError{Overlaps: overlaps}
+	}
+	warnings := make([]string, 0, len(overlaps))
+	for _, overlap := range overlaps {
+		warnings = append(warnings, overlap.Warning())
+	}
+	return warnings, nil
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// alternativeSuggestions is how many other start times an expiry email suggests
+	alternativeSuggestions = 3
+	// alternativeSearchDays is how many days ahead alternatives are looked for
+	alternativeSearchDays = 7
+)
+
+// SuggestAlternatives finds up to limit start times from from onwards at which the appointment's
+// service could be booked instead, with any available staff member. Candidates follow the
+// business hours, spaced by the appointment's length plus the service buffer.
+func SuggestAlternatives(appointment *models.Appointment, from time.Time, limit int) ([]time.Time, error) {
+	var service models.Service
+	if err := db.DB.First(&service, appointment.ServiceID).Error; err != nil {
+		return nil, fmt.Errorf("service not found")
+	}
+	duration := appointment.Duration()
+	step := duration + service.BufferTime
+	if step <= 0 {
+		return nil, nil
+	}
+
+	loc := ProviderLocation(appointment.ProviderID)
+	day := from.In(loc)
+	var found []time.Time
+	for d := 0; d < alternativeSearchDays && len(found) < limit; d++ {
+		schedule, err := GetDaySchedule(appointment.ProviderID, nil, day.AddDate(0, 0, d))
+		if err != nil {
+			return found, err
+		}
+		if schedule.Closed {
+			continue
+		}
+		for _, shift := range schedule.Shifts {
+			for start := shift.Start; !start.Add(duration).After(shift.End) && len(found) < limit; start = start.Add(step) {
+				if start.Before(from) || !schedule.Fits(start, duration) {
+					continue
+				}
+				// The booking engine has the final say; nothing is written here
+				err := db.DB.Transaction(func(tx *gorm.DB) error {
+					_, err := ReserveStaffSlot(tx, SlotRequest{
+						ProviderID: appointment.ProviderID,
+						ServiceID:  service.ID,
+						CustomerID: appointment.CustomerID,
+						Capacity:   service.Seats(),
+						StartTime:  start,
+						Duration:   duration,
+						BufferTime: service.BufferTime,
+					})
+					return err
+				})
+				if err == nil {
+					found = append(found, start.UTC())
+				} else if !IsBookingConflict(err) {
+					return found, err
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// ExpirePendingAppointments expires pending bookings whose provider did not confirm them before
+// the provider's confirmation deadline. The slot is freed, offered to the waitlist, and the
+// customer is emailed a few other times to book instead. It returns how many were expired.
+func ExpirePendingAppointments(now time.Time) (int, error) {
+	var pending []models.Appointment
+	if err := db.DB.Preload("Customer").Preload("Provider").Preload("Service").
+		Joins("JOIN provider_settings ON provider_settings.provider_id = appointments.provider_id AND provider_settings.deleted_at IS NULL").
+		Where("appointments.status = ? AND provider_settings.confirmation_deadline_hours > 0", models.StatusPending).
+		Find(&pending).Error; err != nil {
+		return 0, err
+	}
+
+	settingsByProvider := map[uint]*models.ProviderSettings{}
+	expired := 0
+	for i := range pending {
+		appointment := &pending[i]
+		settings, ok := settingsByProvider[appointment.ProviderID]
+		if !ok {
+			var err error
+			if settings, err = LoadProviderSettings(db.DB, appointment.ProviderID); err != nil {
+				log.Printf("Failed to load settings of provider %d: %v", appointment.ProviderID, err)
+				continue
+			}
+			settingsByProvider[appointment.ProviderID] = settings
+		}
+		deadline, ok := settings.ConfirmationDeadline(appointment.CreatedAt, appointment.StartTime)
+		if !ok || deadline.After(now) {
+			continue
+		}
+
+		// Only expire the booking if the provider did not confirm it in the meantime
+		changed := false
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.Appointment{}).
+				Where("id = ? AND status = ?", appointment.ID, models.StatusPending).
+				Update("status", models.StatusExpired)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			changed = true
+			return models.RecordEvent(tx, models.AppointmentEvent{
+				AppointmentID: appointment.ID,
+				Type:          models.EventStatusChanged,
+				Field:         "status",
+				OldValue:      string(models.StatusPending),
+				NewValue:      string(models.StatusExpired),
+				Reason:        "Not confirmed by the provider before the confirmation deadline",
+			}, models.SystemActor)
+		})
+		if err != nil {
+			log.Printf("Failed to expire appointment %d: %v", appointment.ID, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		appointment.Status = models.StatusExpired
+		expired++
+
+		if appointment.StartTime.After(now) {
+			OfferFreedSlot(appointment)
+		}
+
+		alternatives, err := SuggestAlternatives(appointment, now, alternativeSuggestions)
+		if err != nil {
+			log.Printf("Failed to find alternatives for appointment %d: %v", appointment.ID, err)
+		}
+		if err := SendEmail(appointment.Customer.Email, "Appointment Request Expired", expiryEmail(appointment, alternatives)); err != nil {
+			log.Printf("Failed to send expiry email for appointment %d: %v", appointment.ID, err)
+		}
+	}
+	return expired, nil
+}
+
+// expiryEmail tells the customer their request expired and lists the suggested times in the
+// provider's time zone
+func expiryEmail(appointment *models.Appointment, alternatives []time.Time) string {
+	loc := ProviderLocation(appointment.ProviderID)
+	suggestions := "<p>We could not find another free time in the coming week. Please choose a new time in the app.</p>"
+	if len(alternatives) > 0 {
+		var items strings.Builder
+		for _, start := range alternatives {
+			items.WriteString(fmt.Sprintf("<li>%s</li>", FormatInZone(start, loc)))
+		}
+		suggestions = fmt.Sprintf("<p>These times are still available:</p><ul>%s</ul>", items.String())
+	}
+	return fmt.Sprintf(`
+		<p>Dear %s,</p>
+		<p>%s did not confirm your request for %s on %s in time, so it has expired and the slot was released.</p>
+		%s
+		<p>Best regards,</p>
+		<p>Your Appointment Team</p>
+	`, appointment.Customer.Name, appointment.Provider.Name, appointment.Service.Name,
+		FormatInZone(appointment.StartTime, loc), suggestions)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+)
+
+// defaultNoShowGraceMinutes is how late a customer may be before the booking counts as a no-show
+const defaultNoShowGraceMinutes = 15
+
+// NoShowGrace returns the grace period after the start time, configurable via NO_SHOW_GRACE_MINUTES
+func NoShowGrace() time.Duration {
+	minutes := defaultNoShowGraceMinutes
+	if v, err := strconv.Atoi(os.Getenv("NO_SHOW_GRACE_MINUTES")); err == nil && v > 0 {
+		minutes = v
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// MarkNoShows marks confirmed appointments as no-shows once the grace period after their start
+// has passed without a check-in, and returns how many were marked
+func MarkNoShows(now time.Time) (int, error) {
+	var appointments []models.Appointment
+	if err := db.DB.Where("status = ? AND start_time <= ?", models.StatusConfirmed, now.Add(-NoShowGrace())).
+		Find(&appointments).Error; err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for i := range appointments {
+		if err := appointments[i].UpdateStatus(db.DB, models.StatusNoShow, models.SystemActor, "Not checked in within the grace period"); err != nil {
+			log.Printf("Failed to mark appointment %d as no-show: %v", appointments[i].ID, err)
+			continue
+		}
+		marked++
+	}
+	return marked, nil
+}
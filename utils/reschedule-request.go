@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// maxRescheduleOptions is how many new times a customer may propose at once
+const maxRescheduleOptions = 5
+
+var (
+	// ErrInvalidProposal is returned when the proposed times cannot be put to the provider
+	ErrInvalidProposal = errors.New("invalid reschedule proposal")
+	// ErrRescheduleRequestOpen is returned when the appointment already awaits a decision
+	ErrRescheduleRequestOpen = errors.New("appointment already has a pending reschedule request")
+	// ErrRescheduleRequestClosed is returned when the request was already decided, withdrawn or expired
+	ErrRescheduleRequestClosed = errors.New("reschedule request is no longer pending")
+)
+
+// ProposeReschedule records the customer's proposal to move their appointment to one of times.
+// Every time must be bookable for the appointment's service and staff member right now, and
+// the provider's cancellation policy must allow a reschedule. The appointment keeps its
+// current slot until the provider decides.
+func ProposeReschedule(appointmentID, customerID uint, times []time.Time, note string) (*models.RescheduleRequest, error) {
+	if len(times) == 0 || len(times) > maxRescheduleOptions {
+		return nil, fmt.Errorf("%w: propose between 1 and %d times", ErrInvalidProposal, maxRescheduleOptions)
+	}
+
+	var appointment models.Appointment
+	if err := db.DB.Preload("Customer").Preload("Provider").Preload("Service").
+		Where("id = ? AND customer_id = ?", appointmentID, customerID).First(&appointment).Error; err != nil {
+		return nil, err
+	}
+	if appointment.Status != models.StatusPending && appointment.Status != models.StatusConfirmed {
+		return nil, fmt.Errorf("%w: only pending or confirmed appointments can be rescheduled", ErrInvalidProposal)
+	}
+
+	request := models.RescheduleRequest{
+		AppointmentID:     appointment.ID,
+		CustomerID:        appointment.CustomerID,
+		ProviderID:        appointment.ProviderID,
+		Status:            models.ReschedulePending,
+		Note:              note,
+		OriginalStartTime: appointment.StartTime,
+	}
+	loc := ProviderLocation(appointment.ProviderID)
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := LockProviderSchedule(tx, appointment.ProviderID); err != nil {
+			return fmt.Errorf("failed to lock provider schedule: %v", err)
+		}
+		var open int64
+		if err := tx.Model(&models.RescheduleRequest{}).
+			Where("appointment_id = ? AND status = ?", appointment.ID, models.ReschedulePending).
+			Count(&open).Error; err != nil {
+			return err
+		}
+		if open > 0 {
+			return ErrRescheduleRequestOpen
+		}
+		if _, err := CheckPolicy(tx, &appointment, PolicyReschedule); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		proposed := map[int64]bool{}
+		for _, start := range times {
+			if !start.After(now) {
+				return fmt.Errorf("%w: proposed times must be in the future", ErrInvalidProposal)
+			}
+			if start.Equal(appointment.StartTime) || proposed[start.Unix()] {
+				return fmt.Errorf("%w: proposed times must differ from each other and from the current time", ErrInvalidProposal)
+			}
+			proposed[start.Unix()] = true
+
+			// Only checked here; the slot is reserved when the provider accepts
+			if err := ReserveSlot(tx, SlotRequest{
+				ProviderID:    appointment.ProviderID,
+				ServiceID:     appointment.ServiceID,
+				StaffID:       appointment.StaffID,
+				CustomerID:    appointment.CustomerID,
+				Capacity:      appointment.Service.Seats(),
+				StartTime:     start,
+				Duration:      appointment.Duration(),
+				BufferTime:    appointment.Service.BufferTime,
+				AppointmentID: appointment.ID,
+			}); err != nil {
+				return fmt.Errorf("%s: %w", FormatInZone(start, loc), err)
+			}
+			// Under the reject policy the customer cannot propose a time they are busy elsewhere
+			if _, err := CheckCustomerOverlaps(tx, CustomerBooking{
+				CustomerID:    appointment.CustomerID,
+				ProviderID:    appointment.ProviderID,
+				StartTime:     start,
+				EndTime:       start.Add(appointment.Duration()),
+				AppointmentID: appointment.ID,
+			}); err != nil {
+				return fmt.Errorf("%s: %w", FormatInZone(start, loc), err)
+			}
+			request.Options = append(request.Options, models.RescheduleOption{
+				StartTime: start.UTC(),
+				EndTime:   start.Add(appointment.Duration()).UTC(),
+			})
+		}
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf(`
+		<p>Dear %s,</p>
+		<p>%s would like to move their %s appointment on %s to one of these times:</p>
+		<ul>%s</ul>
+		%s
+		<p>The current time stays booked until you accept one of them or decline.</p>
+		<p>Best regards,</p>
+		<p>Your Appointment Team</p>
+	`, appointment.Provider.Name, appointment.Customer.Name, appointment.Service.Name,
+		FormatInZone(appointment.StartTime, loc), optionList(request.Options, loc), noteParagraph(note))
+	if err := SendEmail(appointment.Provider.Email, "Reschedule Request", body); err != nil {
+		log.Printf("Failed to send reschedule request email for appointment %d: %v", appointment.ID, err)
+	}
+	return &request, nil
+}
+
+// AcceptReschedule moves the appointment to the chosen option of a pending request, counting
+// it as a reschedule under the cancellation policy, and offers the old slot to the waitlist.
+// A request whose appointment was moved, canceled or started in the meantime expires instead.
+func AcceptReschedule(requestID, optionID, providerID uint, actor models.Actor) (*models.Appointment, *models.RescheduleRequest, error) {
+	var request models.RescheduleRequest
+	var appointment models.Appointment
+	var previous models.Appointment
+	stale := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := LockProviderSchedule(tx, providerID); err != nil {
+			return fmt.Errorf("failed to lock provider schedule: %v", err)
+		}
+		if err := tx.Preload("Options").Where("id = ? AND provider_id = ?", requestID, providerID).First(&request).Error; err != nil {
+			return err
+		}
+		if request.Status != models.ReschedulePending {
+			return ErrRescheduleRequestClosed
+		}
+		var option *models.RescheduleOption
+		for i := range request.Options {
+			if request.Options[i].ID == optionID {
+				option = &request.Options[i]
+			}
+		}
+		if option == nil {
+			return fmt.Errorf("%w: option %d is not one of the proposed times", ErrInvalidProposal, optionID)
+		}
+
+		if err := tx.Preload("Service").First(&appointment, request.AppointmentID).Error; err != nil {
+			return err
+		}
+		if (appointment.Status != models.StatusPending && appointment.Status != models.StatusConfirmed) ||
+			!appointment.StartTime.Equal(request.OriginalStartTime) {
+			stale = true
+			request.Status = models.RescheduleExpired
+			return tx.Model(&request).Update("status", request.Status).Error
+		}
+		if !option.StartTime.After(time.Now()) {
+			return fmt.Errorf("%w: the proposed time has passed", ErrInvalidProposal)
+		}
+		if _, err := CheckPolicy(tx, &appointment, PolicyReschedule); err != nil {
+			return err
+		}
+
+		if err := ReserveSlot(tx, SlotRequest{
+			ProviderID:    appointment.ProviderID,
+			ServiceID:     appointment.ServiceID,
+			StaffID:       appointment.StaffID,
+			CustomerID:    appointment.CustomerID,
+			Capacity:      appointment.Service.Seats(),
+			StartTime:     option.StartTime,
+			Duration:      appointment.Duration(),
+			BufferTime:    appointment.Service.BufferTime,
+			AppointmentID: appointment.ID,
+		}); err != nil {
+			return err
+		}
+		warnings, err := CheckCustomerOverlaps(tx, CustomerBooking{
+			CustomerID:    appointment.CustomerID,
+			ProviderID:    appointment.ProviderID,
+			StartTime:     option.StartTime,
+			EndTime:       option.EndTime,
+			AppointmentID: appointment.ID,
+		})
+		if err != nil {
+			return err
+		}
+		appointment.Warnings = warnings
+
+		previous = appointment
+		appointment.StartTime = option.StartTime
+		appointment.EndTime = option.EndTime
+		appointment.RescheduleCount++
+		if err := tx.Model(&appointment).Updates(map[string]interface{}{
+			"start_time":       appointment.StartTime,
+			"end_time":         appointment.EndTime,
+			"reschedule_count": appointment.RescheduleCount,
+		}).Error; err != nil {
+			return err
+		}
+		if err := models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventRescheduled,
+			Field:         "start_time",
+			OldValue:      models.EventTime(previous.StartTime),
+			NewValue:      models.EventTime(appointment.StartTime),
+			Reason:        fmt.Sprintf("Customer's reschedule request %d accepted", request.ID),
+		}, actor); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		request.Status = models.RescheduleAccepted
+		request.AcceptedOptionID = &option.ID
+		request.DecidedBy = actor.ID
+		request.DecidedAt = &now
+		return tx.Model(&request).Updates(map[string]interface{}{
+			"status":             request.Status,
+			"accepted_option_id": request.AcceptedOptionID,
+			"decided_by":         request.DecidedBy,
+			"decided_at":         request.DecidedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if stale {
+		return nil, &request, fmt.Errorf("%w: the appointment changed since the times were proposed", ErrRescheduleRequestClosed)
+	}
+
+	OfferFreedSlot(&previous)
+	notifyRescheduleDecision(&appointment, &request, previous.StartTime)
+	return &appointment, &request, nil
+}
+
+// DeclineReschedule turns the request down; the appointment stays at its current time
+func DeclineReschedule(requestID, providerID uint, actor models.Actor, reason string) (*models.RescheduleRequest, error) {
+	var request models.RescheduleRequest
+	if err := db.DB.Preload("Options").Where("id = ? AND provider_id = ?", requestID, providerID).First(&request).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := db.DB.Model(&models.RescheduleRequest{}).
+		Where("id = ? AND status = ?", request.ID, models.ReschedulePending).
+		Updates(map[string]interface{}{
+			"status":     models.RescheduleDeclined,
+			"decided_by": actor.ID,
+			"decided_at": now,
+			"response":   reason,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRescheduleRequestClosed
+	}
+	request.Status = models.RescheduleDeclined
+	request.DecidedBy = actor.ID
+	request.DecidedAt = &now
+	request.Response = reason
+
+	var appointment models.Appointment
+	if err := db.DB.First(&appointment, request.AppointmentID).Error; err == nil {
+		notifyRescheduleDecision(&appointment, &request, appointment.StartTime)
+	}
+	return &request, nil
+}
+
+// WithdrawReschedule lets the customer take back a request the provider has not decided yet
+func WithdrawReschedule(requestID, appointmentID, customerID uint) (*models.RescheduleRequest, error) {
+	var request models.RescheduleRequest
+	if err := db.DB.Preload("Options").Where("id = ? AND appointment_id = ? AND customer_id = ?", requestID, appointmentID, customerID).
+		First(&request).Error; err != nil {
+		return nil, err
+	}
+
+	result := db.DB.Model(&models.RescheduleRequest{}).
+		Where("id = ? AND status = ?", request.ID, models.ReschedulePending).
+		Update("status", models.RescheduleWithdrawn)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRescheduleRequestClosed
+	}
+	request.Status = models.RescheduleWithdrawn
+
+	var appointment models.Appointment
+	if err := db.DB.Preload("Customer").Preload("Provider").Preload("Service").First(&appointment, request.AppointmentID).Error; err == nil {
+		body := fmt.Sprintf(`
+			<p>Dear %s,</p>
+			<p>%s withdrew their request to move the %s appointment on %s. The appointment stays as booked.</p>
+			<p>Best regards,</p>
+			<p>Your Appointment Team</p>
+		`, appointment.Provider.Name, appointment.Customer.Name, appointment.Service.Name,
+			FormatInZone(appointment.StartTime, ProviderLocation(appointment.ProviderID)))
+		if err := SendEmail(appointment.Provider.Email, "Reschedule Request Withdrawn", body); err != nil {
+			log.Printf("Failed to send withdrawal email for appointment %d: %v", appointment.ID, err)
+		}
+	}
+	return &request, nil
+}
+
+// ExpireRescheduleRequests closes pending requests none of whose proposed times is still
+// ahead, and returns how many were closed
+func ExpireRescheduleRequests(now time.Time) (int64, error) {
+	result := db.DB.Model(&models.RescheduleRequest{}).
+		Where("status = ?", models.ReschedulePending).
+		Where("NOT EXISTS (SELECT 1 FROM reschedule_options WHERE reschedule_options.request_id = reschedule_requests.id AND reschedule_options.start_time > ?)", now.UTC()).
+		Update("status", models.RescheduleExpired)
+	return result.RowsAffected, result.Error
+}
+
+// notifyRescheduleDecision emails the customer the provider's answer to their request
+func notifyRescheduleDecision(appointment *models.Appointment, request *models.RescheduleRequest, previousStart time.Time) {
+	var customer, provider models.User
+	if err := db.DB.First(&customer, appointment.CustomerID).Error; err != nil {
+		log.Printf("Failed to load customer of appointment %d: %v", appointment.ID, err)
+		return
+	}
+	if err := db.DB.First(&provider, appointment.ProviderID).Error; err != nil {
+		log.Printf("Failed to load provider of appointment %d: %v", appointment.ID, err)
+		return
+	}
+
+	loc := ProviderLocation(appointment.ProviderID)
+	subject := "Reschedule Request Accepted"
+	outcome := fmt.Sprintf("<p>%s accepted your request. Your appointment on %s has moved to %s.</p>",
+		provider.Name, FormatInZone(previousStart, loc), FormatInZone(appointment.StartTime, loc))
+	if request.Status == models.RescheduleDeclined {
+		subject = "Reschedule Request Declined"
+		outcome = fmt.Sprintf("<p>%s could not accept any of the times you proposed. Your appointment stays on %s.</p>%s",
+			provider.Name, FormatInZone(appointment.StartTime, loc), noteParagraph(request.Response))
+	}
+	body := fmt.Sprintf(`
+		<p>Dear %s,</p>
+		%s
+		<p>Best regards,</p>
+		<p>Your Appointment Team</p>
+	`, customer.Name, outcome)
+	if err := SendEmail(customer.Email, subject, body); err != nil {
+		log.Printf("Failed to send reschedule decision email for appointment %d: %v", appointment.ID, err)
+	}
+}
+
+// optionList renders the proposed times as list items in the provider's time zone
+func optionList(options []models.RescheduleOption, loc *time.Location) string {
+	var items strings.Builder
+	for _, option := range options {
+		items.WriteString(fmt.Sprintf("<li>%s</li>", FormatInZone(option.StartTime, loc)))
+	}
+	return items.String()
+}
+
+// noteParagraph quotes a free-text note, or renders nothing when it is empty
+func noteParagraph(note string) string {
+	if note == "" {
+		return ""
+	}
+	return fmt.Sprintf("<p>Note: %s</p>", html.EscapeString(note))
+}
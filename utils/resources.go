@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// ErrResourceUnavailable is returned when a resource the service needs is fully in use
+var ErrResourceUnavailable = errors.New("required resource is not available")
+
+// ResourceShortageError names the resource that ran out
+type ResourceShortageError struct {
+	ResourceID uint   `json:"resource_id"`
+	Name       string `json:"name"`
+	Needed     int    `json:"needed"`
+	Available  int    `json:"available"`
+}
+
+func (e *ResourceShortageError) Error() string {
+	return fmt.Sprintf("resource %q not available: %d needed, %d free", e.Name, e.Needed, e.Available)
+}
+
+func (e *ResourceShortageError) Unwrap() error {
+	return ErrResourceUnavailable
+}
+
+// ResourceClaim is a booking, hold or offer that occupies the resources of its service
+type ResourceClaim struct {
+	ServiceID uint
+	StaffID   *uint
+	Start     time.Time
+	End       time.Time
+	Session   bool // Group session; attendees of the same session share the resources
+}
+
+// sameSession reports whether two claims are seats of one group session
+func (c ResourceClaim) sameSession(other ResourceClaim) bool {
+	return c.Session && other.Session && c.ServiceID == other.ServiceID &&
+		c.Start.Equal(other.Start) && SameStaff(c.StaffID, other.StaffID)
+}
+
+// ResourceNeeds maps a service ID to the resources it needs
+type ResourceNeeds map[uint][]models.ServiceResource
+
+// LoadResourceNeeds returns the resource needs of all the provider's services
+func LoadResourceNeeds(tx *gorm.DB, providerID uint) (ResourceNeeds, error) {
+	var rows []models.ServiceResource
+	if err := tx.Preload("Resource").
+		Joins("JOIN services ON services.id = service_resources.service_id AND services.deleted_at IS NULL").
+		Where("services.provider_id = ?", providerID).
+		Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load service resources: %v", err)
+	}
+	needs := ResourceNeeds{}
+	for _, row := range rows {
+		needs[row.ServiceID] = append(needs[row.ServiceID], row)
+	}
+	return needs, nil
+}
+
+// resourceUses returns the claims between start and end that use the resource, with the
+// units each one takes. A group session uses its resources once, however many attendees it has.
+func (n ResourceNeeds) resourceUses(resourceID uint, claims []ResourceClaim, start, end time.Time) ([]ResourceClaim, []int) {
+	var uses []ResourceClaim
+	var units []int
+	for _, claim := range claims {
+		if !claim.Start.Before(end) || !claim.End.After(start) {
+			continue
+		}
+		duplicate := false
+		for _, seen := range uses {
+			if claim.sameSession(seen) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		for _, need := range n[claim.ServiceID] {
+			if need.ResourceID == resourceID {
+				uses = append(uses, claim)
+				units = append(units, need.Quantity)
+				break
+			}
+		}
+	}
+	return uses, units
+}
+
+// PeakUsage returns the most units of the resource in use at once between start and end
+func (n ResourceNeeds) PeakUsage(resourceID uint, claims []ResourceClaim, start, end time.Time) int {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	uses, units := n.resourceUses(resourceID, claims, start, end)
+	events := make([]event, 0, 2*len(uses))
+	for i, claim := range uses {
+		from := claim.Start
+		if from.Before(start) {
+			from = start
+		}
+		events = append(events, event{from, units[i]}, event{claim.End, -units[i]})
+	}
+
+	// Ends sort before starts at the same instant so back-to-back bookings do not stack
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+	peak, current := 0, 0
+	for _, e := range events {
+		current += e.delta
+		if current > peak {
+			peak = current
+		}
+	}
+	return peak
+}
+
+// Usage returns how many bookings used the resource between start and end and the unit
+// minutes they took, clipped to the period
+func (n ResourceNeeds) Usage(resourceID uint, claims []ResourceClaim, start, end time.Time) (int, float64) {
+	uses, units := n.resourceUses(resourceID, claims, start, end)
+	unitMinutes := 0.0
+	for i, claim := range uses {
+		from, to := claim.Start, claim.End
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		unitMinutes += to.Sub(from).Minutes() * float64(units[i])
+	}
+	return len(uses), unitMinutes
+}
+
+// Check reports the first resource the request cannot get alongside the existing claims.
+// Claims that are seats of the same group session as the request do not count against it.
+func (n ResourceNeeds) Check(request ResourceClaim, claims []ResourceClaim) error {
+	needs := n[request.ServiceID]
+	if len(needs) == 0 {
+		return nil
+	}
+	others := make([]ResourceClaim, 0, len(claims))
+	for _, claim := range claims {
+		if !request.sameSession(claim) {
+			others = append(others, claim)
+		}
+	}
+	for _, need := range needs {
+		free := need.Resource.Quantity - n.PeakUsage(need.ResourceID, others, request.Start, request.End)
+		if need.Quantity > free {
+			if free < 0 {
+				free = 0
+			}
+			return &ResourceShortageError{
+				ResourceID: need.ResourceID,
+				Name:       need.Resource.Name,
+				Needed:     need.Quantity,
+				Available:  free,
+			}
+		}
+	}
+	return nil
+}
+
+// AppointmentClaims turns appointments with their Service loaded into resource claims
+func AppointmentClaims(appointments []models.Appointment) []ResourceClaim {
+	claims := make([]ResourceClaim, 0, len(appointments))
+	for _, a := range appointments {
+		claims = append(claims, ResourceClaim{
+			ServiceID: a.ServiceID,
+			StaffID:   a.StaffID,
+			Start:     a.StartTime,
+			End:       a.EndTime,
+			Session:   a.Service.Seats() > 1,
+		})
+	}
+	return claims
+}
+
+// HoldClaims turns checkout holds into resource claims
+func HoldClaims(holds []SlotHold, capacities map[uint]int) []ResourceClaim {
+	claims := make([]ResourceClaim, 0, len(holds))
+	for _, h := range holds {
+		claims = append(claims, ResourceClaim{
+			ServiceID: h.ServiceID,
+			StaffID:   h.StaffID,
+			Start:     h.StartTime,
+			End:       h.EndTime,
+			Session:   capacities[h.ServiceID] > 1,
+		})
+	}
+	return claims
+}
+
+// CheckResources makes sure every resource the requested service needs is free for the
+// whole appointment, counting other bookings, checkout holds and waitlist offers
+func CheckResources(tx *gorm.DB, req SlotRequest) error {
+	needs, err := LoadResourceNeeds(tx, req.ProviderID)
+	if err != nil {
+		return err
+	}
+	if len(needs[req.ServiceID]) == 0 {
+		return nil
+	}
+
+	start := req.StartTime.UTC()
+	end := start.Add(req.Duration)
+	var appointments []models.Appointment
+	if err := tx.Preload("Service").
+		Where("provider_id = ? AND id != ? AND status IN ? AND start_time < ? AND end_time > ?",
+			req.ProviderID, req.AppointmentID, models.ActiveStatuses, end, start).
+		Find(&appointments).Error; err != nil {
+		return err
+	}
+	claims := AppointmentClaims(appointments)
+
+	var services []models.Service
+	if err := tx.Where("provider_id = ?", req.ProviderID).Find(&services).Error; err != nil {
+		return err
+	}
+	capacities := make(map[uint]int, len(services))
+	for _, s := range services {
+		capacities[s.ID] = s.Seats()
+	}
+
+	holds, err := ProviderSlotHolds(req.ProviderID)
+	if err != nil {
+		return err
+	}
+	others := holds[:0]
+	for _, h := range holds {
+		if h.CustomerID != req.CustomerID {
+			others = append(others, h)
+		}
+	}
+	claims = append(claims, HoldClaims(others, capacities)...)
+
+	var offers []models.WaitlistOffer
+	if err := tx.Where("provider_id = ? AND customer_id != ? AND status = ? AND expires_at > ? AND start_time < ? AND end_time > ?",
+		req.ProviderID, req.CustomerID, models.OfferPending, time.Now(), end, start).
+		Find(&offers).Error; err != nil {
+		return err
+	}
+	for _, o := range offers {
+		claims = append(claims, ResourceClaim{
+			ServiceID: o.ServiceID,
+			StaffID:   o.StaffID,
+			Start:     o.StartTime,
+			End:       o.EndTime,
+			Session:   capacities[o.ServiceID] > 1,
+		})
+	}
+
+	return needs.Check(ResourceClaim{
+		ServiceID: req.ServiceID,
+		StaffID:   req.StaffID,
+		Start:     start,
+		End:       end,
+		Session:   req.Capacity > 1,
+	}, claims)
+}
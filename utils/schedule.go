@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+)
+
+// dateLayout is the format of the dates stored on availability overrides
+const dateLayout = "2006-01-02"
+
+// TimeRange is a span of wall-clock time on a specific date
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// DaySchedule is a provider's working time on one calendar date, with the weekly
+// working hours and any availability overrides for that date applied
+type DaySchedule struct {
+	Date   time.Time   `json:"date"`
+	Closed bool        `json:"closed"`
+	Reason string      `json:"reason,omitempty"`
+	Shifts []TimeRange `json:"shifts"`
+	Breaks []TimeRange `json:"breaks"`
+}
+
+// Fits reports whether the whole span from start for duration lies inside a single
+// shift without overlapping a break
+func (d *DaySchedule) Fits(start time.Time, duration time.Duration) bool {
+	if d.Closed {
+		return false
+	}
+	end := start.Add(duration)
+	for _, b := range d.Breaks {
+		if b.Start.Before(end) && b.End.After(start) {
+			return false
+		}
+	}
+	for _, s := range d.Shifts {
+		if !start.Before(s.Start) && !end.After(s.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// clockOn combines an "HH:MM" string with the calendar date of day
+func clockOn(day time.Time, clock string) (time.Time, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), parsed.Hour(), parsed.Minute(), 0, 0, day.Location()), nil
+}
+
+// clockRange builds a TimeRange on day from two "HH:MM" strings
+func clockRange(day time.Time, start, end string) (TimeRange, error) {
+	s, err := clockOn(day, start)
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("invalid start time format")
+	}
+	e, err := clockOn(day, end)
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("invalid end time format")
+	}
+	return TimeRange{Start: s, End: e}, nil
+}
+
+// GetDaySchedule resolves the provider's working time on the calendar date of day,
+// interpreted in day's location. Overrides win over the weekly hours: a closure
+// closes the whole day, custom hours replace the weekly shifts, and override breaks
+// are added to the weekly breaks.
+//
+// With a staffID the staff member's own weekly hours are used, or the business hours
+// when they have none, and both business-wide and staff overrides apply.
+func GetDaySchedule(providerID uint, staffID *uint, day time.Time) (*DaySchedule, error) {
+	date := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	schedule := &DaySchedule{Date: date}
+
+	var hoursOwner *uint
+	if staffID != nil {
+		var own int64
+		if err := db.DB.Model(&models.WorkingHours{}).Where("provider_id = ? AND staff_id = ?", providerID, *staffID).
+			Count(&own).Error; err != nil {
+			return nil, fmt.Errorf("staff working hours not found")
+		}
+		if own > 0 {
+			hoursOwner = staffID
+		}
+	}
+
+	var weekly []models.WorkingHours
+	if err := db.DB.Preload("Breaks").Where("provider_id = ? AND day_of_week = ?", providerID, models.DayOfWeek(date.Weekday())).
+		Where("staff_id IS NOT DISTINCT FROM ?", hoursOwner).
+		Find(&weekly).Error; err != nil {
+		return nil, fmt.Errorf("provider working hours not found")
+	}
+	for _, wh := range weekly {
+		shift, err := clockRange(date, wh.StartTime, wh.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		schedule.Shifts = append(schedule.Shifts, shift)
+		if wh.BreakStart != nil && wh.BreakEnd != nil {
+			brk, err := clockRange(date, *wh.BreakStart, *wh.BreakEnd)
+			if err != nil {
+				return nil, fmt.Errorf("invalid break time format")
+			}
+			schedule.Breaks = append(schedule.Breaks, brk)
+		}
+		for _, b := range wh.Breaks {
+			brk, err := clockRange(date, b.StartTime, b.EndTime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid break time format")
+			}
+			schedule.Breaks = append(schedule.Breaks, brk)
+		}
+	}
+
+	var overrides []models.AvailabilityOverride
+	dateStr := date.Format(dateLayout)
+	if err := db.DB.Preload("Breaks").
+		Where("provider_id = ? AND start_date <= ? AND end_date >= ?", providerID, dateStr, dateStr).
+		Where("staff_id IS NULL OR staff_id = ?", staffID).
+		Order("id asc").
+		Find(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch availability overrides: %v", err)
+	}
+
+	for _, o := range overrides {
+		if o.IsClosed {
+			schedule.Closed = true
+			schedule.Reason = o.Reason
+			schedule.Shifts = nil
+			schedule.Breaks = nil
+			return schedule, nil
+		}
+		if o.StartTime != nil && o.EndTime != nil {
+			shift, err := clockRange(date, *o.StartTime, *o.EndTime)
+			if err != nil {
+				return nil, err
+			}
+			schedule.Shifts = []TimeRange{shift}
+		}
+		for _, b := range o.Breaks {
+			brk, err := clockRange(date, b.StartTime, b.EndTime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid break time format")
+			}
+			schedule.Breaks = append(schedule.Breaks, brk)
+		}
+		if o.Reason != "" {
+			schedule.Reason = o.Reason
+		}
+	}
+
+	if len(schedule.Shifts) == 0 {
+		schedule.Closed = true
+	}
+	sort.Slice(schedule.Shifts, func(i, j int) bool {
+		return schedule.Shifts[i].Start.Before(schedule.Shifts[j].Start)
+	})
+	return schedule, nil
+}
@@ -0,0 +1,492 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// SeriesScope selects which occurrences of a recurring series a change applies to
+type SeriesScope string
+
+const (
+	ScopeThis      SeriesScope = "this"
+	ScopeFollowing SeriesScope = "following"
+	ScopeAll       SeriesScope = "all"
+)
+
+// Outcomes reported per occurrence by series changes
+const (
+	OccurrenceScheduled   = "scheduled"
+	OccurrenceCanceled    = "canceled"
+	OccurrenceRescheduled = "rescheduled"
+	OccurrenceConflict    = "conflict"
+	OccurrenceFlagged     = "flagged"
+	OccurrenceFailed      = "failed"
+)
+
+// defaultRecurrenceHorizonWeeks is how far ahead recurring occurrences are booked
+const defaultRecurrenceHorizonWeeks = 4
+
+// OccurrenceResult reports the outcome of a series change for one occurrence
+type OccurrenceResult struct {
+	AppointmentID uint      `json:"appointment_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	Warnings      []string  `json:"warnings,omitempty"` // Clashes with the customer's other appointments
+	// Policy is the cancellation policy's decision when the customer changed the series
+	Policy *PolicyDecision `json:"policy,omitempty"`
+}
+
+// ParseSeriesScope validates a scope value from a request
+func ParseSeriesScope(scope string) (SeriesScope, error) {
+	switch SeriesScope(scope) {
+	case ScopeThis, ScopeFollowing, ScopeAll:
+		return SeriesScope(scope), nil
+	case "":
+		return ScopeThis, nil
+	default:
+		return "", fmt.Errorf("invalid scope %q: must be 'this', 'following' or 'all'", scope)
+	}
+}
+
+// seriesOccurrences returns the upcoming pending and confirmed occurrences covered by scope.
+// Occurrences that have passed or that the front desk has already checked in stay as they are.
+func seriesOccurrences(tx *gorm.DB, appointment *models.Appointment, scope SeriesScope) ([]models.Appointment, error) {
+	if scope == ScopeThis || !appointment.IsRecurring || appointment.RecurrenceID == 0 {
+		return []models.Appointment{*appointment}, nil
+	}
+
+	query := tx.Where("recurrence_id = ? AND status IN ? AND start_time > ?", appointment.RecurrenceID,
+		[]models.AppointmentStatus{models.StatusPending, models.StatusConfirmed}, time.Now().UTC())
+	if scope == ScopeFollowing {
+		query = query.Where("start_time >= ?", appointment.StartTime)
+	}
+
+	var occurrences []models.Appointment
+	if err := query.Order("start_time asc").Find(&occurrences).Error; err != nil {
+		return nil, err
+	}
+	return occurrences, nil
+}
+
+// seriesReason explains in an occurrence's history that it changed along with others
+func seriesReason(verb string, scope SeriesScope) string {
+	switch scope {
+	case ScopeFollowing:
+		return verb + " with the following occurrences of the series"
+	case ScopeAll:
+		return verb + " with all occurrences of the series"
+	default:
+		return ""
+	}
+}
+
+// CancelSeries cancels the occurrences of appointment's series selected by scope on behalf of
+// actor. Canceling "following" or "all" also ends the series so no new occurrences are created.
+// With applyPolicy, as for a customer's change, the provider's cancellation policy decides each
+// occurrence on its own: refused ones are kept as conflicts and late ones are charged the fee.
+func CancelSeries(appointment *models.Appointment, scope SeriesScope, actor models.Actor, applyPolicy bool) ([]OccurrenceResult, error) {
+	occurrences, err := seriesOccurrences(db.DB, appointment, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]OccurrenceResult, 0, len(occurrences))
+	for i := range occurrences {
+		occ := &occurrences[i]
+		result := OccurrenceResult{AppointmentID: occ.ID, StartTime: occ.StartTime, EndTime: occ.EndTime}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			var decision *PolicyDecision
+			if applyPolicy {
+				var err error
+				decision, err = CheckPolicy(tx, occ, PolicyCancel)
+				result.Policy = decision
+				if err != nil {
+					return err
+				}
+			}
+			if err := occ.UpdateStatus(tx, models.StatusCanceled, actor, seriesReason("Canceled", scope)); err != nil {
+				return err
+			}
+			if decision == nil {
+				return nil
+			}
+			return RecordCancellationFee(tx, occ, decision, actor)
+		})
+		switch {
+		case err == nil:
+			result.Status = OccurrenceCanceled
+			// Offer the freed slot to the next customer on the waitlist
+			OfferFreedSlot(occ)
+		case errors.Is(err, ErrPolicyViolation):
+			result.Status = OccurrenceConflict
+			result.Error = err.Error()
+		default:
+			result.Status = OccurrenceFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	// Canceling a single occurrence leaves the rest of the series untouched
+	if scope == ScopeThis || !appointment.IsRecurring || appointment.RecurrenceID == 0 {
+		return results, nil
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var recurrence models.Recurrence
+		if err := tx.First(&recurrence, appointment.RecurrenceID).Error; err != nil {
+			return fmt.Errorf("failed to load recurrence: %v", err)
+		}
+
+		// Everything up to this occurrence is already materialized, so ending the
+		// rule here stops the series for both "following" and "all"
+		recurrence.EndBefore(appointment.OccurrenceStart(), appointment.OccurrenceStart())
+		recurrence.Ended = true
+		return tx.Save(&recurrence).Error
+	})
+	return results, err
+}
+
+// RescheduleSeries moves appointment to newStart on behalf of actor and, for "following"
+// and "all", shifts the other occurrences in scope by the same offset. Every occurrence goes
+// through the booking engine on its own, so a conflict only fails that occurrence. With
+// applyPolicy the provider's cancellation policy must allow each move, which counts towards
+// that occurrence's reschedule limit.
+func RescheduleSeries(appointment *models.Appointment, scope SeriesScope, newStart time.Time, actor models.Actor, applyPolicy bool) ([]OccurrenceResult, error) {
+	var service models.Service
+	if err := db.DB.First(&service, appointment.ServiceID).Error; err != nil {
+		return nil, fmt.Errorf("service not found")
+	}
+
+	occurrences, err := seriesOccurrences(db.DB, appointment, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Occurrences move by calendar days and wall-clock time in the series time zone, so a
+	// move across a DST change keeps their local time
+	var recurrence models.Recurrence
+	if scope != ScopeThis && appointment.IsRecurring && appointment.RecurrenceID != 0 {
+		if err := db.DB.First(&recurrence, appointment.RecurrenceID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load recurrence: %v", err)
+		}
+	}
+	loc := ProviderLocation(appointment.ProviderID)
+	if recurrence.TimeZone != "" {
+		loc = LoadTimeZone(recurrence.TimeZone)
+	}
+	shift := models.NewWallShift(appointment.StartTime, newStart, loc)
+
+	results := make([]OccurrenceResult, 0, len(occurrences))
+	for i := range occurrences {
+		occ := &occurrences[i]
+		start := shift.Apply(occ.StartTime).UTC()
+		// Each occurrence keeps its own length, which includes any add-ons
+		duration := occ.Duration()
+		result := OccurrenceResult{AppointmentID: occ.ID, StartTime: start, EndTime: start.Add(duration)}
+
+		var warnings []string
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if applyPolicy {
+				decision, err := CheckPolicy(tx, occ, PolicyReschedule)
+				result.Policy = decision
+				if err != nil {
+					return err
+				}
+				occ.RescheduleCount++
+			}
+			if err := ReserveSlot(tx, SlotRequest{
+				ProviderID:    occ.ProviderID,
+				ServiceID:     service.ID,
+				StaffID:       occ.StaffID,
+				CustomerID:    occ.CustomerID,
+				Capacity:      service.Seats(),
+				StartTime:     start,
+				Duration:      duration,
+				BufferTime:    service.BufferTime,
+				AppointmentID: occ.ID,
+			}); err != nil {
+				return err
+			}
+			var err error
+			warnings, err = CheckCustomerOverlaps(tx, CustomerBooking{
+				CustomerID:    occ.CustomerID,
+				ProviderID:    occ.ProviderID,
+				StartTime:     start,
+				EndTime:       start.Add(duration),
+				AppointmentID: occ.ID,
+			})
+			if err != nil {
+				return err
+			}
+
+			// A single moved occurrence remembers its series slot
+			if scope == ScopeThis && occ.IsRecurring && occ.OriginalStartTime == nil {
+				original := occ.StartTime
+				occ.OriginalStartTime = &original
+			}
+			previous := occ.StartTime
+			occ.StartTime = start
+			occ.EndTime = start.Add(duration)
+			if err := tx.Omit("RecurPattern").Save(occ).Error; err != nil {
+				return err
+			}
+			return models.RecordEvent(tx, models.AppointmentEvent{
+				AppointmentID: occ.ID,
+				Type:          models.EventRescheduled,
+				Field:         "start_time",
+				OldValue:      models.EventTime(previous),
+				NewValue:      models.EventTime(start),
+				Reason:        seriesReason("Moved", scope),
+			}, actor)
+		})
+		switch {
+		case err == nil:
+			result.Status = OccurrenceRescheduled
+			result.Warnings = warnings
+		case IsBookingConflict(err) || errors.Is(err, ErrPolicyViolation):
+			result.Status = OccurrenceConflict
+			result.Error = err.Error()
+		default:
+			result.Status = OccurrenceFailed
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	if scope == ScopeThis || !appointment.IsRecurring || appointment.RecurrenceID == 0 {
+		return results, nil
+	}
+
+	// Move the rule as well so future occurrences follow the new time
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&recurrence, appointment.RecurrenceID).Error; err != nil {
+			return fmt.Errorf("failed to load recurrence: %v", err)
+		}
+		// The rule now follows wall-clock time in the zone the occurrences moved in
+		if recurrence.TimeZone == "" {
+			recurrence.TimeZone = loc.String()
+		}
+		current := appointment.OccurrenceStart()
+
+		if scope == ScopeAll {
+			recurrence.Shift(current, shift)
+			return tx.Save(&recurrence).Error
+		}
+
+		next, err := recurrence.Split(current, current, shift.Apply(current).UTC())
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(&recurrence).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&next).Error; err != nil {
+			return fmt.Errorf("failed to create recurrence: %v", err)
+		}
+		ids := make([]uint, 0, len(occurrences))
+		for _, occ := range occurrences {
+			ids = append(ids, occ.ID)
+		}
+		return tx.Model(&models.Appointment{}).Where("id IN ?", ids).Update("recurrence_id", next.ID).Error
+	})
+	return results, err
+}
+
+// RecurrenceHorizon returns the end of the window recurring occurrences are booked into.
+// The window is RECURRENCE_HORIZON_WEEKS weeks long, four by default.
+func RecurrenceHorizon(now time.Time) time.Time {
+	weeks, err := strconv.Atoi(os.Getenv("RECURRENCE_HORIZON_WEEKS"))
+	if err != nil || weeks <= 0 {
+		weeks = defaultRecurrenceHorizonWeeks
+	}
+	return now.AddDate(0, 0, 7*weeks)
+}
+
+// MaterializeRecurrence books every occurrence of the series due before horizon and
+// advances NextRun. Occurrences that conflict or fall outside working hours are
+// recorded as RecurrenceFlags instead of being skipped silently.
+func MaterializeRecurrence(recurrence *models.Recurrence, horizon time.Time) ([]OccurrenceResult, error) {
+	var template models.Appointment
+	if err := db.DB.Unscoped().Preload("Options").First(&template, recurrence.AppointmentID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load series appointment: %v", err)
+	}
+	var service models.Service
+	if err := db.DB.Unscoped().First(&service, template.ServiceID).Error; err != nil {
+		return nil, fmt.Errorf("service not found")
+	}
+
+	// Most recent materialized occurrence, including canceled and deleted ones
+	var latest struct {
+		Latest *time.Time
+	}
+	if err := db.DB.Unscoped().Model(&models.Appointment{}).
+		Select("MAX(COALESCE(original_start_time, start_time)) AS latest").
+		Where("recurrence_id = ?", recurrence.ID).
+		Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+	latestTime := recurrence.NextRun.Add(-time.Second)
+	if latest.Latest != nil {
+		latestTime = *latest.Latest
+	}
+
+	// Never book further ahead than the provider accepts; later runs pick the rest up
+	settings, err := LoadProviderSettings(db.DB, template.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+	if windowEnd, ok := settings.BookingWindowEnd(time.Now()); ok && windowEnd.Before(horizon) {
+		horizon = windowEnd
+	}
+
+	// Series created before time zone support follow the provider's current zone
+	if recurrence.TimeZone == "" {
+		recurrence.TimeZone = LoadTimeZone(settings.TimeZone).String()
+	}
+
+	rule, due, err := recurrence.DueOccurrences(latestTime, horizon)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recurrence rule: %v", err)
+	}
+
+	// Occurrences repeat the variant and add-ons chosen for the series
+	duration := template.Duration()
+
+	results := make([]OccurrenceResult, 0, len(due))
+	cursor := latestTime
+	for _, start := range due {
+		// Skip occurrences that were already booked, moved, canceled or deleted
+		var existing int64
+		db.DB.Unscoped().Model(&models.Appointment{}).
+			Where("recurrence_id = ? AND (start_time = ? OR original_start_time = ?)", recurrence.ID, start, start).
+			Count(&existing)
+		if existing > 0 {
+			cursor = start
+			continue
+		}
+
+		occurrence := models.Appointment{
+			Title:        template.Title,
+			Description:  template.Description,
+			StartTime:    start.UTC(),
+			EndTime:      start.Add(duration).UTC(),
+			Status:       settings.InitialStatus(),
+			IsRecurring:  true,
+			RecurrenceID: recurrence.ID,
+			ServiceID:    template.ServiceID,
+			ProviderID:   template.ProviderID,
+			StaffID:      template.StaffID,
+			CustomerID:   template.CustomerID,
+			Options:      CopyOptions(template.Options),
+			// Every occurrence costs what the series was booked for
+			ListPrice:      template.ListPrice,
+			DiscountAmount: template.DiscountAmount,
+			TaxAmount:      template.TaxAmount,
+			TotalAmount:    template.TotalAmount,
+		}
+		result := OccurrenceResult{StartTime: occurrence.StartTime, EndTime: occurrence.EndTime}
+
+		var warnings []string
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := ReserveSlot(tx, SlotRequest{
+				ProviderID: occurrence.ProviderID,
+				ServiceID:  service.ID,
+				StaffID:    occurrence.StaffID,
+				CustomerID: occurrence.CustomerID,
+				Capacity:   service.Seats(),
+				StartTime:  start,
+				Duration:   duration,
+				BufferTime: service.BufferTime,
+			}); err != nil {
+				return err
+			}
+			var err error
+			warnings, err = CheckCustomerOverlaps(tx, CustomerBooking{
+				CustomerID: occurrence.CustomerID,
+				ProviderID: occurrence.ProviderID,
+				StartTime:  start,
+				EndTime:    start.Add(duration),
+			})
+			if err != nil {
+				return err
+			}
+			if err := tx.Omit("RecurPattern").Create(&occurrence).Error; err != nil {
+				return err
+			}
+			return models.RecordEvent(tx, models.AppointmentEvent{
+				AppointmentID: occurrence.ID,
+				Type:          models.EventCreated,
+				Reason:        "Booked from the recurring series",
+			}, models.SystemActor)
+		})
+		switch {
+		case err == nil:
+			result.AppointmentID = occurrence.ID
+			result.Status = OccurrenceScheduled
+			result.Warnings = warnings
+		case IsBookingConflict(err):
+			flag := models.RecurrenceFlag{
+				RecurrenceID:   recurrence.ID,
+				ProviderID:     template.ProviderID,
+				CustomerID:     template.CustomerID,
+				ServiceID:      template.ServiceID,
+				OccurrenceTime: start,
+				Reason:         err.Error(),
+			}
+			if err := db.DB.Create(&flag).Error; err != nil {
+				return results, fmt.Errorf("failed to flag occurrence: %v", err)
+			}
+			result.Status = OccurrenceFlagged
+			result.Error = err.Error()
+		default:
+			// Retry this occurrence on the next run
+			recurrence.NextRun = start
+			db.DB.Save(recurrence)
+			return results, err
+		}
+		results = append(results, result)
+		cursor = start
+	}
+
+	// Advance NextRun past everything handled in this run
+	if next, ok := rule.After(cursor); ok {
+		recurrence.NextRun = next
+	} else {
+		recurrence.Ended = true
+	}
+	if err := db.DB.Save(recurrence).Error; err != nil {
+		return results, fmt.Errorf("failed to update recurrence: %v", err)
+	}
+	return results, nil
+}
+
+// SeriesChangeEmail builds the notification body summarizing a series change, with
+// times shown in loc
+func SeriesChangeEmail(name, action string, results []OccurrenceResult, loc *time.Location) string {
+	items := ""
+	for _, r := range results {
+		line := fmt.Sprintf("%s - %s: %s", FormatInZone(r.StartTime, loc), r.EndTime.In(loc).Format("15:04"), r.Status)
+		if r.Error != "" {
+			line += " (" + r.Error + ")"
+		}
+		items += "<li>" + line + "</li>"
+	}
+	return fmt.Sprintf(`
+		<p>Dear %s,</p>
+		<p>The following appointments in your recurring series have been %s:</p>
+		<ul>%s</ul>
+		<p>Best regards,</p>
+		<p>Your Appointment Team</p>
+	`, name, action, items)
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidOption is returned when a chosen variant or add-on is not offered by the service
+var ErrInvalidOption = errors.New("invalid service option")
+
+// ServiceSelection is the variant and add-ons a customer picked for a service
+type ServiceSelection struct {
+	VariantID *uint  `json:"variant_id"`
+	AddOnIDs  []uint `json:"add_on_ids"`
+}
+
+// ServiceQuote is the length and price of a service with the chosen options
+type ServiceQuote struct {
+	Duration time.Duration
+	Cost     float64                    // Before the service discount
+	Price    float64                    // After the service discount
+	Options  []models.AppointmentOption // Snapshot of the chosen options for the appointment
+}
+
+// ParseSelection reads a selection from the variant_id and comma separated add_on_ids query values
+func ParseSelection(variant, addOns string) (ServiceSelection, error) {
+	var sel ServiceSelection
+	if variant != "" {
+		id, err := strconv.ParseUint(variant, 10, 32)
+		if err != nil {
+			return sel, fmt.Errorf("%w: invalid variant_id %q", ErrInvalidOption, variant)
+		}
+		variantID := uint(id)
+		sel.VariantID = &variantID
+	}
+	for _, value := range strings.Split(addOns, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return sel, fmt.Errorf("%w: invalid add-on ID %q", ErrInvalidOption, value)
+		}
+		sel.AddOnIDs = append(sel.AddOnIDs, uint(id))
+	}
+	return sel, nil
+}
+
+// QuoteService works out the duration and price of the service with the selected options.
+// A variant replaces the service's own duration and cost, add-ons are added on top, and the
+// service discount applies to the total.
+func QuoteService(tx *gorm.DB, service *models.Service, sel ServiceSelection) (*ServiceQuote, error) {
+	quote := &ServiceQuote{Duration: service.Duration, Cost: service.Cost}
+
+	ids := append([]uint{}, sel.AddOnIDs...)
+	if sel.VariantID != nil {
+		ids = append(ids, *sel.VariantID)
+	}
+	if len(ids) > 0 {
+		var options []models.ServiceOption
+		if err := tx.Where("id IN ? AND service_id = ?", ids, service.ID).Find(&options).Error; err != nil {
+			return nil, fmt.Errorf("failed to load service options: %v", err)
+		}
+		byID := make(map[uint]models.ServiceOption, len(options))
+		for _, option := range options {
+			byID[option.ID] = option
+		}
+
+		if sel.VariantID != nil {
+			variant, ok := byID[*sel.VariantID]
+			if !ok || variant.Kind != models.OptionVariant {
+				return nil, fmt.Errorf("%w: %d is not a variant of %s", ErrInvalidOption, *sel.VariantID, service.Name)
+			}
+			quote.Duration = variant.Duration
+			quote.Cost = variant.Cost
+			quote.Options = append(quote.Options, snapshotOption(variant))
+		}
+
+		seen := map[uint]bool{}
+		for _, id := range sel.AddOnIDs {
+			addOn, ok := byID[id]
+			if !ok || addOn.Kind != models.OptionAddOn {
+				return nil, fmt.Errorf("%w: %d is not an add-on of %s", ErrInvalidOption, id, service.Name)
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("%w: add-on %q chosen twice", ErrInvalidOption, addOn.Name)
+			}
+			seen[id] = true
+			quote.Duration += addOn.Duration
+			quote.Cost += addOn.Cost
+			quote.Options = append(quote.Options, snapshotOption(addOn))
+		}
+	}
+
+	quote.Price = quote.Cost - (quote.Cost * service.Discount / 100)
+	return quote, nil
+}
+
+// snapshotOption copies an option onto the appointment so later edits to the service do not change it
+func snapshotOption(option models.ServiceOption) models.AppointmentOption {
+	return models.AppointmentOption{
+		OptionID: option.ID,
+		Kind:     option.Kind,
+		Name:     option.Name,
+		Duration: option.Duration,
+		Cost:     option.Cost,
+	}
+}
+
+// CopyOptions returns the appointment's options ready to be saved on another appointment
+func CopyOptions(options []models.AppointmentOption) []models.AppointmentOption {
+	copies := make([]models.AppointmentOption, 0, len(options))
+	for _, option := range options {
+		copies = append(copies, models.AppointmentOption{
+			OptionID: option.OptionID,
+			Kind:     option.Kind,
+			Name:     option.Name,
+			Duration: option.Duration,
+			Cost:     option.Cost,
+		})
+	}
+	return copies
+}
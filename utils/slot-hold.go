@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"github.com/meinhoongagan/appointment-app/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// defaultSlotHoldMinutes is how long a slot stays held while the customer checks out
+const defaultSlotHoldMinutes = 5
+
+// SlotHold keeps a provider slot for one customer while they complete the booking.
+// Holds live in Redis and disappear on their own once the TTL runs out. Each provider keeps
+// an index of its holders, so reading a calendar's holds never scans the keyspace.
+type SlotHold struct {
+	ProviderID uint      `json:"provider_id"`
+	ServiceID  uint      `json:"service_id"`
+	StaffID    *uint     `json:"staff_id,omitempty"`
+	CustomerID uint      `json:"customer_id"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"` // Includes the service buffer time
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Overlaps reports whether the hold covers any part of [start, end)
+func (h *SlotHold) Overlaps(start, end time.Time) bool {
+	return h.StartTime.Before(end) && h.EndTime.After(start)
+}
+
+// SlotHoldTTL returns how long holds last, configurable via SLOT_HOLD_MINUTES
+func SlotHoldTTL() time.Duration {
+	minutes := defaultSlotHoldMinutes
+	if v, err := strconv.Atoi(os.Getenv("SLOT_HOLD_MINUTES")); err == nil && v > 0 {
+		minutes = v
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// slotHoldKey identifies a customer's hold on a provider; a customer holds one slot per provider
+func slotHoldKey(providerID, customerID uint) string {
+	return fmt.Sprintf("slot_hold:%d:%d", providerID, customerID)
+}
+
+// providerHoldsKey is the sorted set of customers holding a slot on the provider, scored by
+// when their hold expires
+func providerHoldsKey(providerID uint) string {
+	return fmt.Sprintf("slot_holds:%d", providerID)
+}
+
+// ProviderSlotHolds returns the active holds on the provider's calendar
+func ProviderSlotHolds(providerID uint) ([]SlotHold, error) {
+	index := providerHoldsKey(providerID)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := redis.Client.ZRemRangeByScore(redis.Ctx, index, "-inf", now).Err(); err != nil {
+		return nil, fmt.Errorf("failed to load slot holds: %v", err)
+	}
+	customers, err := redis.Client.ZRangeByScore(redis.Ctx, index, &goredis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load slot holds: %v", err)
+	}
+	if len(customers) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(customers))
+	for _, customer := range customers {
+		keys = append(keys, fmt.Sprintf("slot_hold:%d:%s", providerID, customer))
+	}
+
+	values, err := redis.Client.MGet(redis.Ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load slot holds: %v", err)
+	}
+	holds := make([]SlotHold, 0, len(values))
+	for _, value := range values {
+		// Holds that expired or were released since the index was read come back empty
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var hold SlotHold
+		if err := json.Unmarshal([]byte(raw), &hold); err != nil {
+			log.Printf("Skipping malformed slot hold: %v", err)
+			continue
+		}
+		holds = append(holds, hold)
+	}
+	return holds, nil
+}
+
+// CheckSlotHolds rejects a booking that overlaps a slot held by another customer on the same
+// calendar. Holds on the same group session take a seat each, so the session is only blocked
+// once the holds use up the seats that are left.
+func CheckSlotHolds(req SlotRequest, seatsLeft int) error {
+	holds, err := ProviderSlotHolds(req.ProviderID)
+	if err != nil {
+		return err
+	}
+
+	end := req.StartTime.Add(req.Duration + req.BufferTime)
+	held := 0
+	for _, hold := range holds {
+		if hold.CustomerID == req.CustomerID || !SameStaff(hold.StaffID, req.StaffID) || !hold.Overlaps(req.StartTime, end) {
+			continue
+		}
+		if req.Capacity > 1 && hold.ServiceID == req.ServiceID && hold.StartTime.Equal(req.StartTime) {
+			held++
+			continue
+		}
+		return ErrSlotHeld
+	}
+	if held >= seatsLeft {
+		return ErrSlotHeld
+	}
+	return nil
+}
+
+// PlaceSlotHold holds a slot for the customer after checking it can be booked. Without a
+// staffID the hold goes to any available staff member. The hold is as long as the service
+// with the selected variant and add-ons. Any earlier hold of the customer on the same
+// provider is replaced.
+func PlaceSlotHold(providerID, serviceID uint, staffID *uint, customerID uint, start time.Time, sel ServiceSelection) (*SlotHold, error) {
+	var service models.Service
+	if err := db.DB.Where("id = ? AND provider_id = ?", serviceID, providerID).First(&service).Error; err != nil {
+		return nil, fmt.Errorf("service not found or does not belong to provider")
+	}
+	quote, err := QuoteService(db.DB, &service, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := SlotHoldTTL()
+	hold := &SlotHold{
+		ProviderID: providerID,
+		ServiceID:  serviceID,
+		CustomerID: customerID,
+		StartTime:  start.UTC(),
+		EndTime:    start.Add(quote.Duration + service.BufferTime).UTC(),
+		ExpiresAt:  time.Now().Add(ttl).UTC(),
+	}
+
+	// Write the hold while the provider's schedule is locked so two customers cannot hold
+	// the same slot at once
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		assigned, err := ReserveStaffSlot(tx, SlotRequest{
+			ProviderID: providerID,
+			ServiceID:  serviceID,
+			StaffID:    staffID,
+			CustomerID: customerID,
+			Capacity:   service.Seats(),
+			StartTime:  start,
+			Duration:   quote.Duration,
+			BufferTime: service.BufferTime,
+		})
+		if err != nil {
+			return err
+		}
+		hold.StaffID = assigned
+
+		value, err := json.Marshal(hold)
+		if err != nil {
+			return err
+		}
+		index := providerHoldsKey(providerID)
+		if _, err := redis.Client.TxPipelined(redis.Ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Set(redis.Ctx, slotHoldKey(providerID, customerID), value, ttl)
+			pipe.ZAdd(redis.Ctx, index, goredis.Z{Score: float64(hold.ExpiresAt.Unix()), Member: customerID})
+			// The index goes away with the last hold when the provider gets no new ones
+			pipe.Expire(redis.Ctx, index, ttl)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to save slot hold: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hold, nil
+}
+
+// ReleaseSlotHold removes the customer's hold on the provider, if any
+func ReleaseSlotHold(providerID, customerID uint) error {
+	_, err := redis.Client.TxPipelined(redis.Ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Del(redis.Ctx, slotHoldKey(providerID, customerID))
+		pipe.ZRem(redis.Ctx, providerHoldsKey(providerID), customerID)
+		return nil
+	})
+	return err
+}
+
+// ConsumeSlotHold releases the hold once the customer's booking went through
+func ConsumeSlotHold(providerID, customerID uint) {
+	if err := ReleaseSlotHold(providerID, customerID); err != nil {
+		log.Printf("Failed to release slot hold of customer %d on provider %d: %v", customerID, providerID, err)
+	}
+}
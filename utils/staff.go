@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrStaffNotFound is returned when the requested staff member is not an active member of the provider
+	ErrStaffNotFound = errors.New("staff member not found")
+	// ErrStaffNotQualified is returned when the requested staff member cannot perform the service
+	ErrStaffNotQualified = errors.New("staff member does not perform this service")
+)
+
+// IsStaffError reports whether err means the requested staff member cannot take the booking
+func IsStaffError(err error) bool {
+	return errors.Is(err, ErrStaffNotFound) || errors.Is(err, ErrStaffNotQualified)
+}
+
+// SameStaff reports whether two staff references point at the same calendar
+func SameStaff(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// QualifiedStaff returns the provider's active staff members assigned the service. An
+// empty result means the provider takes the booking on their own calendar.
+func QualifiedStaff(tx *gorm.DB, providerID, serviceID uint) ([]models.StaffMember, error) {
+	var staff []models.StaffMember
+	if err := tx.Joins("JOIN staff_services ON staff_services.staff_member_id = staff_members.id").
+		Where("staff_members.provider_id = ? AND staff_members.active = ? AND staff_services.service_id = ?", providerID, true, serviceID).
+		Order("staff_members.id asc").
+		Find(&staff).Error; err != nil {
+		return nil, fmt.Errorf("failed to load staff: %v", err)
+	}
+	return staff, nil
+}
+
+// CheckStaffMember verifies the staff member works for the provider and performs the service
+func CheckStaffMember(tx *gorm.DB, providerID, serviceID, staffID uint) error {
+	var staff models.StaffMember
+	if err := tx.Preload("Services").Where("id = ? AND provider_id = ? AND active = ?", staffID, providerID, true).
+		First(&staff).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrStaffNotFound
+		}
+		return err
+	}
+	if !staff.Performs(serviceID) {
+		return ErrStaffNotQualified
+	}
+	return nil
+}
+
+// ReserveStaffSlot reserves the slot with the requested staff member or, when none is named,
+// with the first qualified staff member free at that time ("any available"). It returns the
+// staff member booked, or nil when the provider has no staff for the service.
+func ReserveStaffSlot(tx *gorm.DB, req SlotRequest) (*uint, error) {
+	if req.StaffID != nil {
+		if err := CheckStaffMember(tx, req.ProviderID, req.ServiceID, *req.StaffID); err != nil {
+			return nil, err
+		}
+		return req.StaffID, ReserveSlot(tx, req)
+	}
+
+	staff, err := QualifiedStaff(tx, req.ProviderID, req.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(staff) == 0 {
+		return nil, ReserveSlot(tx, req)
+	}
+
+	for _, member := range staff {
+		staffID := member.ID
+		req.StaffID = &staffID
+		err := ReserveSlot(tx, req)
+		if err == nil {
+			return &staffID, nil
+		}
+		if !IsBookingConflict(err) {
+			return nil, err
+		}
+		// Closures, the booking window, resources and booking limits apply to the whole business, not just this staff member
+		if errors.Is(err, ErrProviderClosed) || errors.Is(err, ErrBeyondBookingWindow) || errors.Is(err, ErrResourceUnavailable) ||
+			errors.Is(err, ErrBookingLimit) {
+			return nil, err
+		}
+	}
+	return nil, ErrNoStaffAvailable
+}
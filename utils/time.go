@@ -1,12 +1,37 @@
 package utils
 
-import "time"
+import (
+	"time"
 
-// ToIST converts UTC time to Indian Standard Time (IST)
-func ToIST(t time.Time) time.Time {
-	ist, err := time.LoadLocation("Asia/Kolkata")
+	"github.com/meinhoongagan/appointment-app/db"
+)
+
+// DefaultTimeZone is used for providers that have not set ProviderSettings.TimeZone
+const DefaultTimeZone = "Asia/Kolkata"
+
+// LoadTimeZone resolves an IANA time zone name, falling back to DefaultTimeZone and then UTC
+func LoadTimeZone(name string) *time.Location {
+	if name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	if loc, err := time.LoadLocation(DefaultTimeZone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// ProviderLocation returns the time zone the provider's working hours are expressed in
+func ProviderLocation(providerID uint) *time.Location {
+	settings, err := LoadProviderSettings(db.DB, providerID)
 	if err != nil {
-		return t // Fallback to UTC if IST is not available
+		return LoadTimeZone("")
 	}
-	return t.In(ist)
+	return LoadTimeZone(settings.TimeZone)
+}
+
+// FormatInZone formats t for display in loc, including the zone abbreviation
+func FormatInZone(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02 15:04 MST")
 }
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// ErrVisitService is returned when a service of a visit does not belong to the provider
+var ErrVisitService = errors.New("service not found or does not belong to provider")
+
+// VisitRequest asks for several services of one provider back to back, in order
+type VisitRequest struct {
+	ProviderID  uint
+	CustomerID  uint
+	StaffID     *uint // Optional staff member for every service, any available when nil
+	StartTime   time.Time
+	ServiceIDs  []uint
+	Description string
+
+	// Selections are the variant and add-ons of each service, in the order of ServiceIDs;
+	// services past the end of the list are booked without options
+	Selections []ServiceSelection
+}
+
+// VisitItem is one line of a visit's itemized price
+type VisitItem struct {
+	AppointmentID uint      `json:"appointment_id"`
+	ServiceID     uint      `json:"service_id"`
+	Name          string    `json:"name"`
+	StaffID       *uint     `json:"staff_id,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	ListPrice     float64   `json:"list_price"`
+	Discount      float64   `json:"discount"` // Amount taken off the list price
+	Tax           float64   `json:"tax"`
+	Price         float64   `json:"price"` // What the customer pays for the service
+}
+
+// VisitItems builds the itemized breakdown of a visit from its appointments with Service loaded
+func VisitItems(appointments []models.Appointment) []VisitItem {
+	items := make([]VisitItem, 0, len(appointments))
+	for _, a := range appointments {
+		items = append(items, VisitItem{
+			AppointmentID: a.ID,
+			ServiceID:     a.ServiceID,
+			Name:          a.Service.Name,
+			StaffID:       a.StaffID,
+			StartTime:     a.StartTime,
+			EndTime:       a.EndTime,
+			ListPrice:     a.ListPrice,
+			Discount:      a.DiscountAmount,
+			Tax:           a.TaxAmount,
+			Price:         a.TotalAmount,
+		})
+	}
+	return items
+}
+
+// BookVisit schedules the services back to back from req.StartTime, each starting after the
+// previous one's duration and buffer time. Every slot is reserved in one transaction, so
+// either the whole visit is booked or nothing is.
+func BookVisit(req VisitRequest) (*models.Visit, []VisitItem, error) {
+	if len(req.ServiceIDs) == 0 {
+		return nil, nil, fmt.Errorf("at least one service is required")
+	}
+	if len(req.Selections) > len(req.ServiceIDs) {
+		return nil, nil, fmt.Errorf("%w: more selections than services", ErrInvalidOption)
+	}
+
+	var services []models.Service
+	if err := db.DB.Where("id IN ? AND provider_id = ?", req.ServiceIDs, req.ProviderID).Find(&services).Error; err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]models.Service, len(services))
+	for _, s := range services {
+		byID[s.ID] = s
+	}
+	for _, id := range req.ServiceIDs {
+		if _, ok := byID[id]; !ok {
+			return nil, nil, fmt.Errorf("%w: %d", ErrVisitService, id)
+		}
+	}
+
+	settings, err := LoadProviderSettings(db.DB, req.ProviderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	visit := models.Visit{
+		CustomerID: req.CustomerID,
+		ProviderID: req.ProviderID,
+		StartTime:  req.StartTime.UTC(),
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Appointments").Create(&visit).Error; err != nil {
+			return err
+		}
+
+		start := visit.StartTime
+		for i, id := range req.ServiceIDs {
+			service := byID[id]
+			// The chosen variant and add-ons set the length and price of the service
+			var sel ServiceSelection
+			if i < len(req.Selections) {
+				sel = req.Selections[i]
+			}
+			quote, err := QuoteService(tx, &service, sel)
+			if err != nil {
+				return err
+			}
+
+			staffID, err := ReserveStaffSlot(tx, SlotRequest{
+				ProviderID: req.ProviderID,
+				ServiceID:  service.ID,
+				StaffID:    req.StaffID,
+				CustomerID: req.CustomerID,
+				Capacity:   service.Seats(),
+				StartTime:  start,
+				Duration:   quote.Duration,
+				BufferTime: service.BufferTime,
+				VisitID:    &visit.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("%s at %s: %w", service.Name, start.Format(time.RFC3339), err)
+			}
+
+			// Created right away so the next service sees it when checking the calendar
+			appointment := models.Appointment{
+				Title:       service.Name,
+				Description: req.Description,
+				StartTime:   start,
+				EndTime:     start.Add(quote.Duration),
+				Status:      settings.InitialStatus(),
+				ServiceID:   service.ID,
+				ProviderID:  req.ProviderID,
+				StaffID:     staffID,
+				CustomerID:  req.CustomerID,
+				VisitID:     &visit.ID,
+				Options:     quote.Options,
+			}
+			appointment.SetPrice(quote.Cost, service.Discount, settings.TaxRate)
+			if err := tx.Omit("RecurPattern").Create(&appointment).Error; err != nil {
+				return err
+			}
+			if err := models.RecordEvent(tx, models.AppointmentEvent{
+				AppointmentID: appointment.ID,
+				Type:          models.EventCreated,
+				Reason:        "Booked as part of a visit",
+			}, models.CustomerActor(req.CustomerID)); err != nil {
+				return err
+			}
+			appointment.Service = service
+			visit.Appointments = append(visit.Appointments, appointment)
+			visit.TotalPrice += appointment.TotalAmount
+
+			start = start.Add(quote.Duration + service.BufferTime)
+		}
+
+		last := visit.Appointments[len(visit.Appointments)-1]
+		visit.EndTime = last.EndTime
+		warnings, err := CheckCustomerOverlaps(tx, CustomerBooking{
+			CustomerID: req.CustomerID,
+			ProviderID: req.ProviderID,
+			StartTime:  visit.StartTime,
+			EndTime:    visit.EndTime,
+			VisitID:    &visit.ID,
+		})
+		if err != nil {
+			return err
+		}
+		visit.Warnings = warnings
+		visit.TotalPrice = math.Round(visit.TotalPrice*100) / 100
+		return tx.Model(&visit).Updates(map[string]interface{}{
+			"end_time":    visit.EndTime,
+			"total_price": visit.TotalPrice,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &visit, VisitItems(visit.Appointments), nil
+}
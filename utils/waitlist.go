@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// defaultWaitlistOfferMinutes is how long a freed slot is held for a waitlisted customer
+const defaultWaitlistOfferMinutes = 30
+
+// ErrOfferNotPending is returned when an offer was already accepted, declined or has expired
+var ErrOfferNotPending = errors.New("offer is no longer available")
+
+// WaitlistOfferTTL returns how long offers are held, configurable via WAITLIST_OFFER_MINUTES
+func WaitlistOfferTTL() time.Duration {
+	minutes := defaultWaitlistOfferMinutes
+	if v, err := strconv.Atoi(os.Getenv("WAITLIST_OFFER_MINUTES")); err == nil && v > 0 {
+		minutes = v
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// CheckWaitlistHolds rejects a booking that overlaps a slot currently offered to another customer
+// on the same calendar
+func CheckWaitlistHolds(tx *gorm.DB, providerID uint, staffID *uint, customerID uint, start, end time.Time) error {
+	var held int64
+	if err := tx.Model(&models.WaitlistOffer{}).
+		Where("provider_id = ? AND customer_id != ? AND status = ? AND expires_at > ? AND start_time < ? AND end_time > ?",
+			providerID, customerID, models.OfferPending, time.Now(), end.UTC(), start.UTC()).
+		Where("staff_id IS NOT DISTINCT FROM ?", staffID).
+		Count(&held).Error; err != nil {
+		return err
+	}
+	if held > 0 {
+		return ErrSlotHeld
+	}
+	return nil
+}
+
+// PendingOffers returns the unexpired slots offered to customers other than customerID on the
+// provider's calendars between from and to; CheckWaitlistHolds rejects bookings over them
+func PendingOffers(tx *gorm.DB, providerID, customerID uint, from, to time.Time) ([]models.WaitlistOffer, error) {
+	var offers []models.WaitlistOffer
+	if err := tx.Where("provider_id = ? AND customer_id != ? AND status = ? AND expires_at > ? AND start_time < ? AND end_time > ?",
+		providerID, customerID, models.OfferPending, time.Now(), to.UTC(), from.UTC()).
+		Find(&offers).Error; err != nil {
+		return nil, fmt.Errorf("failed to load waitlist offers: %v", err)
+	}
+	return offers, nil
+}
+
+// WaitlistSelection returns the variant and add-ons the customer is waiting for
+func WaitlistSelection(entry *models.WaitlistEntry) ServiceSelection {
+	var sel ServiceSelection
+	for _, option := range entry.Options {
+		if option.Kind == models.OptionVariant {
+			variantID := option.OptionID
+			sel.VariantID = &variantID
+			continue
+		}
+		sel.AddOnIDs = append(sel.AddOnIDs, option.OptionID)
+	}
+	return sel
+}
+
+// WaitlistOptions records the options of a quote on a waitlist entry
+func WaitlistOptions(quote *ServiceQuote) []models.WaitlistOption {
+	options := make([]models.WaitlistOption, 0, len(quote.Options))
+	for _, option := range quote.Options {
+		options = append(options, models.WaitlistOption{OptionID: option.OptionID, Kind: option.Kind})
+	}
+	return options
+}
+
+// OfferSlot offers a free slot to the next customer waiting for the service within that date
+// whose chosen variant and add-ons fit into it. A slot freed on a staff member's calendar only
+// goes to customers waiting for that staff member or for anyone. Customers who were already
+// offered the same slot are skipped. It returns nil when nobody is waiting or the slot is no
+// longer free.
+func OfferSlot(providerID, serviceID uint, staffID *uint, start time.Time) (*models.WaitlistOffer, error) {
+	if !start.After(time.Now()) {
+		return nil, nil
+	}
+
+	var service models.Service
+	if err := db.DB.First(&service, serviceID).Error; err != nil {
+		return nil, fmt.Errorf("service not found")
+	}
+
+	var offer *models.WaitlistOffer
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Preload("Options").
+			Where("provider_id = ? AND service_id = ? AND status = ? AND from_date <= ? AND to_date >= ?",
+				providerID, serviceID, models.WaitlistWaiting, start.UTC(), start.UTC()).
+			Where("NOT EXISTS (SELECT 1 FROM waitlist_offers WHERE waitlist_offers.entry_id = waitlist_entries.id AND waitlist_offers.start_time = ?)", start.UTC())
+		if staffID != nil {
+			query = query.Where("staff_id IS NULL OR staff_id = ?", *staffID)
+		}
+		var entries []models.WaitlistEntry
+		if err := query.Order("created_at asc").Find(&entries).Error; err != nil {
+			return err
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			// Options removed from the service since the customer joined cannot be offered
+			quote, err := QuoteService(tx, &service, WaitlistSelection(entry))
+			if errors.Is(err, ErrInvalidOption) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			// The slot must still be bookable for the customer's selection before it is offered
+			calendar := staffID
+			if calendar == nil {
+				calendar = entry.StaffID
+			}
+			assigned, err := ReserveStaffSlot(tx, SlotRequest{
+				ProviderID: providerID,
+				ServiceID:  serviceID,
+				StaffID:    calendar,
+				CustomerID: entry.CustomerID,
+				Capacity:   service.Seats(),
+				StartTime:  start,
+				Duration:   quote.Duration,
+				BufferTime: service.BufferTime,
+			})
+			if err != nil {
+				// A closure or the booking window rules the slot out for everyone
+				if !IsBookingConflict(err) || errors.Is(err, ErrProviderClosed) || errors.Is(err, ErrBeyondBookingWindow) {
+					return err
+				}
+				continue
+			}
+
+			offer = &models.WaitlistOffer{
+				EntryID:    entry.ID,
+				CustomerID: entry.CustomerID,
+				ProviderID: providerID,
+				ServiceID:  serviceID,
+				StaffID:    assigned,
+				StartTime:  start.UTC(),
+				EndTime:    start.Add(quote.Duration).UTC(),
+				ExpiresAt:  time.Now().Add(WaitlistOfferTTL()),
+				Status:     models.OfferPending,
+			}
+			if err := tx.Create(offer).Error; err != nil {
+				return err
+			}
+			return tx.Model(entry).Update("status", models.WaitlistOffered).Error
+		}
+		return nil
+	})
+	if err != nil {
+		if IsBookingConflict(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if offer != nil {
+		if err := sendWaitlistOfferEmail(offer, &service); err != nil {
+			log.Printf("Failed to send waitlist offer %d: %v", offer.ID, err)
+		}
+	}
+	return offer, nil
+}
+
+// OfferFreedSlot offers the slot of a canceled appointment to the waitlist
+func OfferFreedSlot(appointment *models.Appointment) {
+	if _, err := OfferSlot(appointment.ProviderID, appointment.ServiceID, appointment.StaffID, appointment.StartTime); err != nil {
+		log.Printf("Failed to offer slot of appointment %d to the waitlist: %v", appointment.ID, err)
+	}
+}
+
+// AcceptWaitlistOffer books the offered slot for the customer
+func AcceptWaitlistOffer(offerID, customerID uint) (*models.Appointment, error) {
+	var appointment models.Appointment
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var offer models.WaitlistOffer
+		if err := tx.Where("id = ? AND customer_id = ?", offerID, customerID).First(&offer).Error; err != nil {
+			return err
+		}
+		if offer.Status != models.OfferPending || !offer.ExpiresAt.After(time.Now()) {
+			return ErrOfferNotPending
+		}
+
+		var service models.Service
+		if err := tx.First(&service, offer.ServiceID).Error; err != nil {
+			return fmt.Errorf("service not found")
+		}
+		// The booking is sized and priced with the variant and add-ons of the waitlist entry
+		var entry models.WaitlistEntry
+		if err := tx.Preload("Options").First(&entry, offer.EntryID).Error; err != nil {
+			return fmt.Errorf("waitlist entry not found")
+		}
+		quote, err := QuoteService(tx, &service, WaitlistSelection(&entry))
+		if err != nil {
+			return err
+		}
+		if err := ReserveSlot(tx, SlotRequest{
+			ProviderID: offer.ProviderID,
+			ServiceID:  offer.ServiceID,
+			StaffID:    offer.StaffID,
+			CustomerID: customerID,
+			Capacity:   service.Seats(),
+			StartTime:  offer.StartTime,
+			Duration:   quote.Duration,
+			BufferTime: service.BufferTime,
+		}); err != nil {
+			return err
+		}
+		warnings, err := CheckCustomerOverlaps(tx, CustomerBooking{
+			CustomerID: customerID,
+			ProviderID: offer.ProviderID,
+			StartTime:  offer.StartTime,
+			EndTime:    offer.StartTime.Add(quote.Duration),
+		})
+		if err != nil {
+			return err
+		}
+
+		settings, err := LoadProviderSettings(tx, offer.ProviderID)
+		if err != nil {
+			return err
+		}
+		appointment = models.Appointment{
+			Title:      service.Name,
+			StartTime:  offer.StartTime.UTC(),
+			EndTime:    offer.StartTime.Add(quote.Duration).UTC(),
+			Status:     settings.InitialStatus(),
+			ServiceID:  offer.ServiceID,
+			ProviderID: offer.ProviderID,
+			StaffID:    offer.StaffID,
+			CustomerID: customerID,
+			Options:    quote.Options,
+			Warnings:   warnings,
+		}
+		appointment.SetPrice(quote.Cost, service.Discount, settings.TaxRate)
+		if err := tx.Omit("RecurPattern").Create(&appointment).Error; err != nil {
+			return err
+		}
+		if err := models.RecordEvent(tx, models.AppointmentEvent{
+			AppointmentID: appointment.ID,
+			Type:          models.EventCreated,
+			Reason:        "Booked from a waitlist offer",
+		}, models.CustomerActor(customerID)); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&offer).Update("status", models.OfferAccepted).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.WaitlistEntry{}).Where("id = ?", offer.EntryID).
+			Updates(map[string]interface{}{"status": models.WaitlistBooked, "appointment_id": appointment.ID}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &appointment, nil
+}
+
+// CloseWaitlistOffer ends a pending offer as declined or expired, puts the customer back
+// on the waitlist unless they left it, and passes the slot on to the next customer
+func CloseWaitlistOffer(offer *models.WaitlistOffer, status models.WaitlistOfferStatus) error {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.WaitlistOffer{}).
+			Where("id = ? AND status = ?", offer.ID, models.OfferPending).
+			Update("status", status)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOfferNotPending
+		}
+		return tx.Model(&models.WaitlistEntry{}).
+			Where("id = ? AND status = ?", offer.EntryID, models.WaitlistOffered).
+			Update("status", models.WaitlistWaiting).Error
+	})
+	if err != nil {
+		return err
+	}
+	offer.Status = status
+
+	_, err = OfferSlot(offer.ProviderID, offer.ServiceID, offer.StaffID, offer.StartTime)
+	return err
+}
+
+// ExpireWaitlistOffers passes every offer whose hold ran out to the next customer
+func ExpireWaitlistOffers() error {
+	var offers []models.WaitlistOffer
+	if err := db.DB.Where("status = ? AND expires_at <= ?", models.OfferPending, time.Now()).
+		Order("expires_at asc").
+		Find(&offers).Error; err != nil {
+		return err
+	}
+	for i := range offers {
+		if err := CloseWaitlistOffer(&offers[i], models.OfferExpired); err != nil && !errors.Is(err, ErrOfferNotPending) {
+			log.Printf("Failed to expire waitlist offer %d: %v", offers[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// sendWaitlistOfferEmail tells the customer a slot opened up and how long it is held
+func sendWaitlistOfferEmail(offer *models.WaitlistOffer, service *models.Service) error {
+	var customer models.User
+	if err := db.DB.First(&customer, offer.CustomerID).Error; err != nil {
+		return err
+	}
+	var provider models.User
+	if err := db.DB.First(&provider, offer.ProviderID).Error; err != nil {
+		return err
+	}
+	loc := ProviderLocation(offer.ProviderID)
+
+	body := fmt.Sprintf(`
+		<p>Dear %s,</p>
+		<p>Good news! A slot you were waiting for has opened up.</p>
+		<p><strong>Details:</strong></p>
+		<ul>
+			<li><strong>Service:</strong> %s</li>
+			<li><strong>Provider:</strong> %s</li>
+			<li><strong>Start Time:</strong> %s</li>
+			<li><strong>End Time:</strong> %s</li>
+		</ul>
+		<p>The slot is held for you until <strong>%s</strong>. Accept offer #%d in the app to book it,
+		otherwise it will be offered to the next customer on the waitlist.</p>
+		<p>Best regards,</p>
+		<p>Your Appointment Team</p>
+	`, customer.Name, service.Name, provider.Name,
+		FormatInZone(offer.StartTime, loc), FormatInZone(offer.EndTime, loc),
+		FormatInZone(offer.ExpiresAt, loc), offer.ID)
+
+	return SendEmail(customer.Email, "A Slot Has Opened Up - "+service.Name, body)
+}
@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/meinhoongagan/appointment-app/db"
+	"github.com/meinhoongagan/appointment-app/models"
+	"gorm.io/gorm"
+)
+
+// QueueEntry is a walk-in with their place in the queue and when they are expected to be seen
+type QueueEntry struct {
+	WalkIn         models.WalkIn `json:"walk_in"`
+	Position       int           `json:"position"`                  // 1 for the next walk-in to be served
+	StaffID        *uint         `json:"staff_id,omitempty"`        // Calendar expected to serve them
+	EstimatedStart *time.Time    `json:"estimated_start,omitempty"` // Nil when no gap is left today
+	WaitMinutes    int           `json:"wait_minutes"`
+}
+
+// walkInSpan is the time a walk-in being served occupies. Service runs for the service duration
+// from when it started, or until now when it is running long.
+func walkInSpan(w *models.WalkIn, now time.Time) TimeRange {
+	start := w.ArrivedAt
+	if w.StartedAt != nil {
+		start = *w.StartedAt
+	}
+	end := start.Add(w.Service.Duration)
+	if end.Before(now) {
+		end = now
+	}
+	return TimeRange{Start: start, End: end.Add(w.Service.BufferTime)}
+}
+
+// WalkInBlock is the time a walk-in being served takes on a calendar
+type WalkInBlock struct {
+	StaffID *uint
+	Span    TimeRange
+}
+
+// ServingWalkIns returns the time taken by the provider's walk-ins being served as of now
+func ServingWalkIns(tx *gorm.DB, providerID uint, now time.Time) ([]WalkInBlock, error) {
+	var serving []models.WalkIn
+	if err := tx.Preload("Service").
+		Where("provider_id = ? AND status = ?", providerID, models.WalkInServing).
+		Find(&serving).Error; err != nil {
+		return nil, fmt.Errorf("failed to load walk-ins: %v", err)
+	}
+	blocks := make([]WalkInBlock, 0, len(serving))
+	for i := range serving {
+		blocks = append(blocks, WalkInBlock{StaffID: serving[i].StaffID, Span: walkInSpan(&serving[i], now)})
+	}
+	return blocks, nil
+}
+
+// CheckWalkIns rejects a booking that overlaps a walk-in being served on the same calendar
+func CheckWalkIns(tx *gorm.DB, req SlotRequest) error {
+	blocks, err := ServingWalkIns(tx, req.ProviderID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	end := req.StartTime.Add(req.Duration + req.BufferTime)
+	for _, block := range blocks {
+		if SameStaff(block.StaffID, req.StaffID) && block.Span.Start.Before(end) && block.Span.End.After(req.StartTime) {
+			return fmt.Errorf("%w: a walk-in is being served", ErrSlotUnavailable)
+		}
+	}
+	return nil
+}
+
+// queueBlock is a booked appointment or walk-in on a calendar as it is expected to run
+type queueBlock struct {
+	start   time.Time
+	length  time.Duration // Includes the buffer time
+	started bool          // In progress, so its start is known
+}
+
+// queueLane is one calendar's working time today and the spans already taken on it
+type queueLane struct {
+	staffID  *uint
+	schedule *DaySchedule
+	busy     []TimeRange
+}
+
+// laneBusy projects the day's blocks from now on. Blocks that have started run for their full
+// length or until now when they are running long; the rest cannot start before now or before
+// the block ahead of them has finished, so a late appointment pushes back the ones after it.
+// Appointments marked completed early are not in blocks and leave their time free.
+func laneBusy(blocks []queueBlock, now time.Time) []TimeRange {
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].start.Before(blocks[j].start) })
+
+	var busy []TimeRange
+	prevEnd := now
+	for _, b := range blocks {
+		start := b.start
+		end := start.Add(b.length)
+		if b.started {
+			if end.Before(now) {
+				end = now
+			}
+		} else {
+			if start.Before(prevEnd) {
+				start = prevEnd
+			}
+			end = start.Add(b.length)
+		}
+		if end.After(prevEnd) {
+			prevEnd = end
+		}
+		busy = append(busy, TimeRange{Start: start, End: end})
+	}
+	return busy
+}
+
+// fit returns the earliest time from from at which length fits in a shift on the lane without
+// overlapping a break or a busy span
+func (l *queueLane) fit(from time.Time, length time.Duration) (time.Time, bool) {
+	candidates := []time.Time{from}
+	for _, b := range l.busy {
+		candidates = append(candidates, b.End)
+	}
+	for _, s := range l.schedule.Shifts {
+		candidates = append(candidates, s.Start)
+	}
+	for _, b := range l.schedule.Breaks {
+		candidates = append(candidates, b.End)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+	for _, start := range candidates {
+		if start.Before(from) || !l.schedule.Fits(start, length) {
+			continue
+		}
+		end := start.Add(length)
+		free := true
+		for _, b := range l.busy {
+			if b.Start.Before(end) && b.End.After(start) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// EstimateQueue places the provider's waiting walk-ins, in order of arrival, into the earliest
+// gaps left today between booked appointments and walk-ins being served. A walk-in who asked
+// for a staff member waits for that calendar; the others go to whichever qualified staff
+// member frees up first. The estimates follow the front desk: checking in, starting or
+// completing an appointment early or late moves every walk-in behind it.
+func EstimateQueue(providerID uint, now time.Time) ([]QueueEntry, error) {
+	var waiting []models.WalkIn
+	if err := db.DB.Preload("Service").
+		Where("provider_id = ? AND status = ?", providerID, models.WalkInWaiting).
+		Order("arrived_at asc, id asc").
+		Find(&waiting).Error; err != nil {
+		return nil, fmt.Errorf("failed to load walk-ins: %v", err)
+	}
+	entries := make([]QueueEntry, 0, len(waiting))
+	if len(waiting) == 0 {
+		return entries, nil
+	}
+
+	settings, err := LoadProviderSettings(db.DB, providerID)
+	if err != nil {
+		return nil, err
+	}
+	now = now.In(LoadTimeZone(settings.TimeZone))
+	dayEnd := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+
+	var serving []models.WalkIn
+	if err := db.DB.Preload("Service").
+		Where("provider_id = ? AND status = ?", providerID, models.WalkInServing).
+		Find(&serving).Error; err != nil {
+		return nil, fmt.Errorf("failed to load walk-ins: %v", err)
+	}
+
+	// Bookings that can still take time today; those that started are kept even if overrunning
+	var appointments []models.Appointment
+	if err := db.DB.Preload("Service").
+		Where("provider_id = ? AND status IN ? AND start_time < ?", providerID, models.ActiveStatuses, dayEnd.UTC()).
+		Where("end_time > ? OR status = ?", now.UTC(), models.StatusInProgress).
+		Find(&appointments).Error; err != nil {
+		return nil, fmt.Errorf("failed to load appointments: %v", err)
+	}
+
+	blocks := map[uint][]queueBlock{}
+	sessions := map[string]bool{}
+	for _, appt := range appointments {
+		// Group session attendees share one block
+		key := fmt.Sprintf("%d:%d:%d", laneKey(appt.StaffID), appt.ServiceID, appt.StartTime.Unix())
+		if sessions[key] {
+			continue
+		}
+		sessions[key] = true
+
+		block := queueBlock{start: appt.StartTime, length: appt.Duration() + appt.Service.BufferTime}
+		if appt.Status == models.StatusInProgress && appt.StartedAt != nil {
+			block.start, block.started = *appt.StartedAt, true
+		}
+		blocks[laneKey(appt.StaffID)] = append(blocks[laneKey(appt.StaffID)], block)
+	}
+	for i := range serving {
+		span := walkInSpan(&serving[i], now)
+		blocks[laneKey(serving[i].StaffID)] = append(blocks[laneKey(serving[i].StaffID)],
+			queueBlock{start: span.Start, length: span.End.Sub(span.Start), started: true})
+	}
+
+	lanes := map[uint]*queueLane{}
+	lane := func(staffID *uint) (*queueLane, error) {
+		if l, ok := lanes[laneKey(staffID)]; ok {
+			return l, nil
+		}
+		schedule, err := GetDaySchedule(providerID, staffID, now)
+		if err != nil {
+			return nil, err
+		}
+		l := &queueLane{staffID: staffID, schedule: schedule, busy: laneBusy(blocks[laneKey(staffID)], now)}
+		lanes[laneKey(staffID)] = l
+		return l, nil
+	}
+
+	for i, walkIn := range waiting {
+		entry := QueueEntry{WalkIn: walkIn, Position: i + 1}
+
+		candidates := []*uint{walkIn.StaffID}
+		if walkIn.StaffID == nil {
+			staff, err := QualifiedStaff(db.DB, providerID, walkIn.ServiceID)
+			if err != nil {
+				return nil, err
+			}
+			if len(staff) > 0 {
+				candidates = candidates[:0]
+				for _, member := range staff {
+					staffID := member.ID
+					candidates = append(candidates, &staffID)
+				}
+			}
+		}
+
+		length := walkIn.Service.Duration + walkIn.Service.BufferTime
+		var best *queueLane
+		var bestStart time.Time
+		for _, staffID := range candidates {
+			l, err := lane(staffID)
+			if err != nil {
+				return nil, err
+			}
+			start, ok := l.fit(now, length)
+			if !ok || settings.IsClosedDuring(start, start.Add(length)) {
+				continue
+			}
+			if best == nil || start.Before(bestStart) {
+				best, bestStart = l, start
+			}
+		}
+
+		if best != nil {
+			best.busy = append(best.busy, TimeRange{Start: bestStart, End: bestStart.Add(length)})
+			entry.StaffID = best.staffID
+			entry.EstimatedStart = &bestStart
+			entry.WaitMinutes = int(bestStart.Sub(now).Round(time.Minute) / time.Minute)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// laneKey identifies a calendar in maps; 0 is the provider's own calendar
+func laneKey(staffID *uint) uint {
+	if staffID == nil {
+		return 0
+	}
+	return *staffID
+}